@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+// errCancelledByAdmin is the cause of tasks cancelled in the admin area.
+var errCancelledByAdmin = errors.New("task cancelled by administrator")
+
+// adminHandler returns the handler of the admin area.
+// All paths of admin area are prefixed with /admin/.
+// standalone means the admin area is served by its own listener without
+// the public pages, so it serves the theme resources itself.
+func adminHandler(standalone bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/", func(w http.ResponseWriter, r *http.Request) {
+		handleAdminDashboard(w, r, standalone)
+	})
+	mux.HandleFunc("/admin/api/tasks", handleAdminTasks)
+	mux.HandleFunc("/admin/api/tasks/", handleAdminTask)
+	if standalone {
+		mux.HandleFunc("/res/", handleRes)
+	}
+	return adminAuthHandler(mux)
+}
+
+// adminAuthHandler checks the admin token before calling h.
+// The token is accepted as a bearer token or the password of basic authentication.
+// Cross-origin POST requests are rejected, because browsers resend cached
+// basic authentication credentials with them.
+func adminAuthHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminToken := getConfig().AdminToken
+		if adminToken == "" {
+			// Admin area is disabled.
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodPost && crossOrigin(r) {
+			requestLogger(r).Warn("cross-origin admin request", "client_ip", clientIP(r), "origin", r.Header.Get("Origin"))
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		var token string
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		} else {
+			_, token, _ = r.BasicAuth()
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			requestLogger(r).Warn("admin authentication failed", "client_ip", clientIP(r))
+			// Increase the cost of brute force.
+			time.Sleep(time.Duration(getConfig().TaskFailDelay))
+			w.Header().Set("WWW-Authenticate", `Basic realm="webfs admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// crossOrigin reports whether r is sent by a browser from another origin.
+// Requests of non-browser clients have neither Sec-Fetch-Site nor Origin.
+func crossOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != r.Host
+	}
+	return false
+}
+
+// adminTask is a task in the admin API.
+type adminTask struct {
+	ID       string      `json:"id"`
+	Created  time.Time   `json:"created"`
+	Deadline time.Time   `json:"deadline"`
+	ClientIP string      `json:"client_ip"`
+	Files    []adminFile `json:"files"`
+	Text     *adminText  `json:"text,omitempty"` // Nil if not a text task.
+}
+
+// adminText is the text of a text task in the admin API.
+// The content is not exposed.
+type adminText struct {
+	Size      int `json:"size"`
+	Downloads int `json:"downloads"`
+}
+
+// adminFile is a file of task in the admin API.
+type adminFile struct {
+	task.FileInfo
+	task.FileStatus
+}
+
+func newAdminTask(t *task.Task) *adminTask {
+	at := &adminTask{
+		ID:       t.ID(),
+		Created:  t.Created(),
+		Deadline: t.Deadline(),
+		ClientIP: t.ClientIP(),
+		Files:    make([]adminFile, 0, t.NFiles()),
+	}
+	if text, ok := t.Text(); ok {
+		at.Text = &adminText{Size: len(text), Downloads: t.TextDownloads()}
+	}
+	for i := 0; i < t.NFiles(); i++ {
+		f := t.File(i)
+		at.Files = append(at.Files, adminFile{f.Info(), f.Status()})
+	}
+	return at
+}
+
+func listAdminTasks() []*adminTask {
+	all := task.All()
+	tasks := make([]*adminTask, 0, len(all))
+	for _, t := range all {
+		tasks = append(tasks, newAdminTask(t))
+	}
+	return tasks
+}
+
+// handleAdminDashboard renders the admin dashboard.
+// A standalone dashboard doesn't link to the public pages.
+func handleAdminDashboard(w http.ResponseWriter, r *http.Request, standalone bool) {
+	if r.URL.Path != "/admin/" {
+		http.NotFound(w, r)
+		return
+	}
+	p := newPage(r)
+	if standalone {
+		// Not under the base path.
+		p.Root, p.Public = "/", false
+	}
+	renderPage(w, r, "admin.html", &struct {
+		page
+		Now   time.Time
+		Tasks []*adminTask
+	}{p, time.Now(), listAdminTasks()})
+}
+
+// handleAdminTasks responds all tasks in JSON.
+//
+//	GET /admin/api/tasks
+func handleAdminTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, r, listAdminTasks())
+}
+
+// handleAdminTask cancels or extends a task.
+//
+//	POST /admin/api/tasks/<id>/cancel
+//	POST /admin/api/tasks/<id>/extend?duration=<duration>
+func handleAdminTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/api/tasks/"), "/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	t := task.Query(id)
+	if t == nil {
+		http.Error(w, "no such task", http.StatusNotFound)
+		return
+	}
+	logger := requestLogger(r).With("task", t.ID())
+	switch action {
+	case "cancel":
+		logger.Info("admin cancel task")
+		t.CtxCancelCause(errCancelledByAdmin)
+		<-t.CtxDone()
+	case "extend":
+		d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		// The remaining time is limited as the timeout of new tasks.
+		max := time.Duration(getConfig().MaxTaskTimeout)
+		if _, err := t.Extend(d, max); err == task.ErrExtendTooLong {
+			http.Error(w, fmt.Sprintf("the task would expire in more than max_task_timeout %v", max), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logger.Info("admin extend task", "duration", d)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, r, newAdminTask(t))
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		requestLogger(r).Warn("failed to write response", "error", err)
+	}
+}
+
+const adminServerEnv = "WEBFS_ADMIN_SERVER"
+const adminTokenEnv = "WEBFS_ADMIN_TOKEN"
+
+// runAdmin runs the "admin" sub command.
+func runAdmin(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("webfs admin", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", envOr(adminServerEnv, "http://localhost"+DefaultServeAddr), "URL of the admin area, also "+adminServerEnv+" environment variable")
+	token := fs.String("token", os.Getenv(adminTokenEnv), "Admin token, also "+adminTokenEnv+" environment variable")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: webfs admin [flags] list|cancel <code>|extend <code> <duration>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	var method, path string
+	switch cmd := fs.Arg(0); {
+	case cmd == "list" && fs.NArg() == 1:
+		method, path = http.MethodGet, "/admin/api/tasks"
+	case cmd == "cancel" && fs.NArg() == 2:
+		method, path = http.MethodPost, "/admin/api/tasks/"+url.PathEscape(fs.Arg(1))+"/cancel"
+	case cmd == "extend" && fs.NArg() == 3:
+		method, path = http.MethodPost, "/admin/api/tasks/"+url.PathEscape(fs.Arg(1))+"/extend?duration="+url.QueryEscape(fs.Arg(2))
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(*server, "/")+path, nil)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(stderr, "%v: %v\n", resp.Status, strings.TrimSpace(string(msg)))
+		return 1
+	}
+
+	var tasks []*adminTask
+	if fs.Arg(0) == "list" {
+		err = json.NewDecoder(resp.Body).Decode(&tasks)
+	} else {
+		var t adminTask
+		err = json.NewDecoder(resp.Body).Decode(&t)
+		tasks = append(tasks, &t)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	printAdminTasks(stdout, tasks)
+	return 0
+}
+
+// printAdminTasks prints tasks as a table.
+func printAdminTasks(w io.Writer, tasks []*adminTask) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tCREATED\tEXPIRES\tCLIENT\tFILE\tSIZE\tSTATE\tSENDER\tRECEIVER\tDOWNLOADS")
+	for _, t := range tasks {
+		code, created, expires, client := t.ID, t.Created.Format(time.DateTime), t.Deadline.Format(time.DateTime), t.ClientIP
+		if t.Text != nil {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t(text)\t%v\t\t\t\t%v\n", code, created, expires, client, t.Text.Size, t.Text.Downloads)
+		} else if len(t.Files) == 0 {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t\t\t\t\t\t\n", code, created, expires, client)
+		}
+		for _, f := range t.Files {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				code, created, expires, client, f.Name, f.Size, f.State, f.SenderIP, f.ReceiverIP, f.Downloads)
+			// Print task columns only in the first line of the task.
+			code, created, expires, client = "", "", "", ""
+		}
+	}
+	tw.Flush()
+}
+
+// envOr returns the value of environment variable env, or def if not set.
+func envOr(env, def string) string {
+	if v, ok := os.LookupEnv(env); ok {
+		return v
+	}
+	return def
+}
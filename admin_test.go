@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+func TestAdmin(t *testing.T) {
+	const adminToken = "admin-secret"
+	setConfig(t, func(c *config) { c.AdminToken = adminToken; c.TaskFailDelay = 0 })
+
+	server := httptest.NewServer(adminHandler(false))
+	defer server.Close()
+
+	ft, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a.txt", Size: 3}}, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.CtxCancel()
+
+	// No token.
+	resp, err := http.Get(server.URL + "/admin/api/tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal(resp.Status)
+	}
+
+	// Basic authentication.
+	req, _ := http.NewRequest("GET", server.URL+"/admin/", nil)
+	req.SetBasicAuth("admin", adminToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.Status)
+	}
+
+	// Bearer token.
+	req, _ = http.NewRequest("GET", server.URL+"/admin/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tasks []*adminTask
+	if err := json.NewDecoder(resp.Body).Decode(&tasks); err != nil {
+		t.Fatal(err)
+	}
+	var found *adminTask
+	for _, at := range tasks {
+		if at.ID == ft.ID() {
+			found = at
+		}
+	}
+	if found == nil || found.ClientIP != "192.0.2.1" || len(found.Files) != 1 ||
+		found.Files[0].Name != "a.txt" || found.Files[0].State != task.FileWaiting {
+		t.Fatal(found)
+	}
+
+	var out, errOut strings.Builder
+	deadline := ft.Deadline()
+	if code := runAdmin([]string{"-server", server.URL, "-token", adminToken, "extend", ft.ID(), "10m"}, &out, &errOut); code != 0 {
+		t.Fatal(code, errOut.String())
+	}
+	if d := ft.Deadline().Sub(deadline); d != 10*time.Minute {
+		t.Fatal(d)
+	}
+	if !strings.Contains(out.String(), ft.ID()) {
+		t.Fatal(out.String())
+	}
+	// Beyond max_task_timeout.
+	if code := runAdmin([]string{"-server", server.URL, "-token", adminToken, "extend", ft.ID(), "1h"}, &out, &errOut); code != 1 {
+		t.Fatal(code)
+	}
+
+	// Cross-origin requests of browsers are rejected.
+	for _, header := range []http.Header{
+		{"Sec-Fetch-Site": {"cross-site"}},
+		{"Origin": {"https://evil.example.com"}},
+	} {
+		req, _ = http.NewRequest("POST", server.URL+"/admin/api/tasks/"+ft.ID()+"/cancel", nil)
+		req.SetBasicAuth("admin", adminToken)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden || ft.CtxErr() != nil {
+			t.Fatal(header, resp.Status)
+		}
+	}
+
+	if code := runAdmin([]string{"-server", server.URL, "-token", adminToken, "cancel", ft.ID()}, &out, &errOut); code != 0 {
+		t.Fatal(code, errOut.String())
+	}
+	if err := ft.CtxErr(); err != errCancelledByAdmin {
+		t.Fatal(err)
+	}
+
+	if code := runAdmin([]string{"-server", server.URL, "-token", "wrong", "list"}, &out, &errOut); code != 1 {
+		t.Fatal(code)
+	}
+	if !strings.Contains(errOut.String(), fmt.Sprint(http.StatusUnauthorized)) {
+		t.Fatal(errOut.String())
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+	setConfig(t, func(c *config) { c.AdminToken = "" })
+	w := httptest.NewRecorder()
+	adminHandler(false).ServeHTTP(w, httptest.NewRequest("GET", "/admin/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestAdminDashboard(t *testing.T) {
+	setConfig(t, func(c *config) { c.AdminToken = "admin-secret" })
+	ft, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a.txt", Size: 3}}, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.CtxCancel()
+
+	r := httptest.NewRequest("GET", "/admin/", nil)
+	r.SetBasicAuth("admin", "admin-secret")
+	r.Header.Set("Accept-Language", "zh-CN")
+	w := httptest.NewRecorder()
+	adminHandler(false).ServeHTTP(w, r)
+	body := w.Body.String()
+	// The shared layout localized.
+	if w.Code != http.StatusOK || !strings.Contains(body, `class="site_header"`) ||
+		!strings.Contains(body, "<title>管理") || !strings.Contains(body, "a.txt（3 字节）- 等待") {
+		t.Fatal(w.Code, body)
+	}
+	// On the admin listener, without the public pages.
+	setConfig(t, func(c *config) { c.BasePath = "/webfs" })
+	w = httptest.NewRecorder()
+	adminHandler(true).ServeHTTP(w, r)
+	body = w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `href="/res/theme.css"`) ||
+		strings.Contains(body, "sw.js") || strings.Contains(body, "manifest") || strings.Contains(body, `href="/"`) {
+		t.Fatal(w.Code, body)
+	}
+	r = httptest.NewRequest("GET", "/res/theme.css", nil)
+	r.SetBasicAuth("admin", "admin-secret")
+	w = httptest.NewRecorder()
+	adminHandler(true).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mkch/webfs/cluster"
+)
+
+// clusterHopHeader marks the requests forwarded by a node of the cluster.
+// They are never forwarded again, even if the nodes disagree on the owner.
+const clusterHopHeader = "X-Webfs-Forwarded"
+
+// requestTaskID returns the ID of the task that r is about, "" if none.
+// basePath is stripped from the path of r.
+func requestTaskID(r *http.Request, basePath string) string {
+	p := strings.TrimPrefix(r.URL.Path, basePath)
+	switch {
+	case strings.HasPrefix(p, "/r/"):
+		return path.Base(p)
+	case strings.HasPrefix(p, "/qr/"):
+		name := path.Base(p)
+		return strings.TrimSuffix(name, path.Ext(name))
+	}
+	return r.URL.Query().Get("task")
+}
+
+// nodeProxy returns the reverse proxy forwarding requests to node.
+func nodeProxy(node string) *httputil.ReverseProxy {
+	target, _ := url.Parse(node)
+	return &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			// The Host header is kept, so that the owner builds the same URLs.
+			r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+			r.Header.Set(clusterHopHeader, "1")
+		},
+		// Relays are streamed.
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			// The request ID of this node is kept.
+			resp.Header.Del("X-Request-Id")
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if r.Context().Err() != nil {
+				return
+			}
+			requestLogger(r).Warn("failed to forward to the owner", "owner", node, "error", err)
+			httpError(w, r, http.StatusBadGateway, "error.node_unavailable")
+		},
+	}
+}
+
+// clusterHandler forwards the requests of tasks owned by other nodes of cl
+// to their owners, and serves the others with h. basePath is the base_path
+// shared by all nodes.
+func clusterHandler(cl *cluster.Cluster, basePath string, h http.Handler) http.Handler {
+	proxies := make(map[string]*httputil.ReverseProxy)
+	for _, node := range cl.Nodes() {
+		if node != cl.Self() {
+			proxies[node] = nodeProxy(node)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestTaskID(r, basePath)
+		if id == "" || cl.Owns(id) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		owner := cl.Owner(id)
+		if r.Header.Get(clusterHopHeader) != "" {
+			requestLogger(r).Warn("forwarded request of a task owned by another node", "task", id, "owner", owner)
+			h.ServeHTTP(w, r)
+			return
+		}
+		forwardedRequests.Inc()
+		requestLogger(r).Debug("forwarding to the owner", "task", id, "owner", owner)
+		proxies[owner].ServeHTTP(w, r)
+	})
+}
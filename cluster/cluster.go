@@ -0,0 +1,86 @@
+// Package cluster assigns task IDs to the nodes of a static cluster with
+// rendezvous hashing. Every node computes the same owner of an ID without
+// any communication, and only a small part of the IDs moves to other nodes
+// when a node is added or removed.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+)
+
+// Cluster is a set of nodes identified by their base URLs.
+type Cluster struct {
+	nodes []string
+	self  string
+}
+
+// New creates a Cluster of nodes, which are base URLs like
+// "http://10.0.0.1:8080". self is the URL of this node in nodes.
+func New(nodes []string, self string) (*Cluster, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("no node")
+	}
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		u, err := url.Parse(node)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("%q is not an http or https URL without path", node)
+		}
+		if seen[node] {
+			return nil, fmt.Errorf("duplicate node %q", node)
+		}
+		seen[node] = true
+	}
+	if !seen[self] {
+		return nil, fmt.Errorf("%q is not a node", self)
+	}
+	return &Cluster{nodes: append([]string(nil), nodes...), self: self}, nil
+}
+
+// Nodes returns the URLs of all nodes.
+func (c *Cluster) Nodes() []string {
+	return append([]string(nil), c.nodes...)
+}
+
+// Self returns the URL of this node.
+func (c *Cluster) Self() string {
+	return c.self
+}
+
+// score returns the weight of node for id. The node of the highest
+// weight owns id.
+func score(node, id string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(node))
+	h.Write([]byte{0})
+	h.Write([]byte(id))
+	// FNV mixes the last bytes poorly, finalize as in SplitMix64.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Owner returns the URL of the node owning id.
+func (c *Cluster) Owner(id string) string {
+	var owner string
+	var max uint64
+	for _, node := range c.nodes {
+		if s := score(node, id); owner == "" || s > max {
+			owner, max = node, s
+		}
+	}
+	return owner
+}
+
+// Owns reports whether this node owns id.
+func (c *Cluster) Owns(id string) bool {
+	return c.Owner(id) == c.self
+}
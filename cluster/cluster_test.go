@@ -0,0 +1,76 @@
+package cluster_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mkch/webfs/cluster"
+)
+
+func TestNew(t *testing.T) {
+	for _, test := range []struct {
+		nodes []string
+		self  string
+	}{
+		{nil, ""},
+		{[]string{"http://a:8080"}, "http://b:8080"},
+		{[]string{"http://a:8080", "http://a:8080"}, "http://a:8080"},
+		{[]string{"ftp://a"}, "ftp://a"},
+		{[]string{"http://a/webfs"}, "http://a/webfs"},
+		{[]string{"a:8080"}, "a:8080"},
+	} {
+		if _, err := cluster.New(test.nodes, test.self); err == nil {
+			t.Fatal(test)
+		}
+	}
+}
+
+func TestOwner(t *testing.T) {
+	nodes := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "https://10.0.0.3/"}
+	var clusters []*cluster.Cluster
+	for _, self := range nodes {
+		c, err := cluster.New(nodes, self)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clusters = append(clusters, c)
+	}
+	less, err := cluster.New(nodes[:2], nodes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 3000
+	owned := make(map[string]int)
+	moved := 0
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("ID%v", i)
+		owner := clusters[0].Owner(id)
+		owners := 0
+		for _, c := range clusters {
+			// All nodes agree.
+			if c.Owner(id) != owner {
+				t.Fatal(id)
+			}
+			if c.Owns(id) {
+				owners++
+			}
+		}
+		if owners != 1 {
+			t.Fatal(id, owners)
+		}
+		owned[owner]++
+		if less.Owner(id) != owner {
+			moved++
+		}
+	}
+	for _, node := range nodes {
+		if owned[node] < n/3*8/10 {
+			t.Fatal(owned)
+		}
+	}
+	// Only the IDs of the removed node move.
+	if moved != owned[nodes[2]] {
+		t.Fatal(moved, owned)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mkch/webfs/cluster"
+	"github.com/mkch/webfs/task"
+)
+
+// newClusterNodes starts a node of a cluster for every name.
+// Responses have the name of the node serving the request in X-Test-Node.
+func newClusterNodes(t *testing.T, names ...string) (map[string]*httptest.Server, map[string]*cluster.Cluster) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new_task", handleNewTask)
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+
+	servers := make(map[string]*httptest.Server)
+	var nodes []string
+	for _, name := range names {
+		servers[name] = httptest.NewUnstartedServer(nil)
+		nodes = append(nodes, "http://"+servers[name].Listener.Addr().String())
+	}
+	clusters := make(map[string]*cluster.Cluster)
+	for i, name := range names {
+		cl, err := cluster.New(nodes, nodes[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		clusters[name] = cl
+		name := name
+		servers[name].Config.Handler = clusterHandler(cl, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test-Node", name)
+			mux.ServeHTTP(w, r)
+		}))
+		servers[name].Start()
+		t.Cleanup(servers[name].Close)
+	}
+	return servers, clusters
+}
+
+func TestCluster(t *testing.T) {
+	setConfig(t, func(c *config) { c.TaskFailDelay = 0 })
+	servers, clusters := newClusterNodes(t, "a", "b")
+	// Tasks are created by node a.
+	task.SetIDFilter(clusters["a"].Owns)
+	defer task.SetIDFilter(nil)
+
+	resp, err := http.Post(servers["a"].URL+"/new_task", "application/json", strings.NewReader(`[{"name":"a.txt","size":5}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct{ ID, Secret string }
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if !clusters["a"].Owns(created.ID) {
+		t.Fatal(created.ID)
+	}
+
+	// The sender and the receiver meet on node a through node b.
+	sent := make(chan error, 1)
+	go func() {
+		resp, err := http.Post(fmt.Sprintf("%v/send_file?task=%v&secret=%v&index=0", servers["b"].URL, created.ID, created.Secret),
+			"application/octet-stream", strings.NewReader("hello"))
+		if err == nil {
+			resp.Body.Close()
+		}
+		sent <- err
+	}()
+	resp, err = http.Get(servers["b"].URL + "/r/" + created.ID + "?index=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" || resp.Header.Get("X-Test-Node") != "a" {
+		t.Fatal(resp.Status, string(body), resp.Header)
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+
+	// Forwarded requests are never forwarded again.
+	unknown := "UNKNOWN0"
+	for i := 1; !clusters["a"].Owns(unknown); i++ {
+		unknown = fmt.Sprintf("UNKNOWN%v", i)
+	}
+	req, _ := http.NewRequest(http.MethodGet, servers["b"].URL+"/r/"+unknown, nil)
+	req.Header.Set(clusterHopHeader, "1")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("X-Test-Node") != "b" {
+		t.Fatal(resp.Header)
+	}
+
+	// The owner is down.
+	servers["a"].Close()
+	resp, err = http.Get(servers["b"].URL + "/r/" + created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatal(resp.Status)
+	}
+}
+
+func TestRequestTaskID(t *testing.T) {
+	for target, id := range map[string]string{
+		"/webfs/r/ABC":                 "ABC",
+		"/webfs/r/ABC?index=1":         "ABC",
+		"/webfs/qr/ABC.svg":            "ABC",
+		"/webfs/send_file?task=ABC":    "ABC",
+		"/webfs/signal?task=ABC&s=1":   "ABC",
+		"/webfs/send":                  "",
+		"/webfs/res/r/not_a_task.html": "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if got := requestTaskID(r, "/webfs"); got != id {
+			t.Fatal(target, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mkch/webfs/task"
+)
+
+// gzipMinSize is the min size of files compressed in relays.
+// Smaller files gain little.
+const gzipMinSize = 1024
+
+// compressibleTypes are the media types worth compressing besides text/*.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-tar":      true,
+	"application/wasm":       true,
+	"application/postscript": true,
+	"image/svg+xml":          true,
+	"image/bmp":              true,
+}
+
+// compressible reports whether a file named name is worth compressing.
+// sniffed is the type detected from the first bytes of the file by
+// http.DetectContentType.
+func compressible(name, sniffed string) bool {
+	typ := mediaType(sniffed)
+	if typ == "application/octet-stream" {
+		// Many formats can't be sniffed, trust the name then.
+		typ = nameType(name)
+	}
+	return strings.HasPrefix(typ, "text/") || strings.HasSuffix(typ, "+xml") ||
+		strings.HasSuffix(typ, "+json") || compressibleTypes[typ]
+}
+
+// acceptsGzip reports whether the client of r accepts gzip encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(item, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+				continue
+			}
+			q := 1.0
+			if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+				q, _ = strconv.ParseFloat(strings.TrimSpace(v), 64)
+			}
+			return q > 0
+		}
+	}
+	return false
+}
+
+// gunzipReader decompresses the gzip stream src. The decompressed
+// stream must have size bytes if size is not negative, see task.NewSizeReader.
+type gunzipReader struct {
+	src  io.Reader
+	size int64
+	r    io.Reader // Created by the first Read, not to block the caller.
+}
+
+func (g *gunzipReader) Read(p []byte) (int, error) {
+	if g.r == nil {
+		zr, err := gzip.NewReader(g.src)
+		if err != nil {
+			return 0, err
+		}
+		g.r = task.NewSizeReader(zr, g.size)
+	}
+	return g.r.Read(p)
+}
+
+// errGzipCheckerClosed stops the decompression of a closed gzipChecker.
+var errGzipCheckerClosed = errors.New("gzip checker closed")
+
+// gzipChecker reads the gzip stream src unchanged, while decompressing it
+// on the side to check it is valid and has the declared size. The error
+// of the check is returned in place of io.EOF.
+type gzipChecker struct {
+	src  io.Reader
+	pw   *io.PipeWriter
+	done chan error // The result of the decompression.
+}
+
+// newGzipChecker returns a gzipChecker checking that src decompresses to
+// size bytes if size is not negative. It must be closed after use.
+func newGzipChecker(src io.Reader, size int64) *gzipChecker {
+	pr, pw := io.Pipe()
+	c := &gzipChecker{src: src, pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := io.Copy(io.Discard, &gunzipReader{src: pr, size: size})
+		pr.CloseWithError(err)
+		c.done <- err
+	}()
+	return c
+}
+
+func (c *gzipChecker) Read(p []byte) (int, error) {
+	n, err := c.src.Read(p)
+	if n > 0 {
+		if _, werr := c.pw.Write(p[:n]); werr != nil {
+			// The decompression failed.
+			return 0, werr
+		}
+	}
+	if err == io.EOF {
+		c.pw.Close()
+		if err := <-c.done; err != nil {
+			return n, err
+		}
+	} else if err != nil {
+		c.pw.CloseWithError(err)
+	}
+	return n, err
+}
+
+// Close stops the decompression.
+func (c *gzipChecker) Close() error {
+	return c.pw.CloseWithError(errGzipCheckerClosed)
+}
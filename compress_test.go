@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                         false,
+		"gzip":                     true,
+		"deflate, gzip;q=1.0, br":  true,
+		"GZIP":                     true,
+		"br, *":                    true,
+		"gzip;q=0":                 false,
+		"identity":                 false,
+		"deflate, x-gzip; q=0.5":   true,
+		"gzip ; q=0.000, deflate ": false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", accept)
+		if got := acceptsGzip(r); got != want {
+			t.Fatal(accept, got)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	for _, test := range []struct {
+		name, sniffed string
+		want          bool
+	}{
+		{"app.log", "text/plain; charset=utf-8", true},
+		{"data.csv", "text/plain; charset=utf-8", true},
+		{"page.html", "text/html; charset=utf-8", true},
+		{"data.json", "application/octet-stream", true},
+		{"logo.svg", "text/xml; charset=utf-8", true},
+		{"photo.jpg", "image/jpeg", false},
+		{"archive.zip", "application/zip", false},
+		{"archive.gz", "application/x-gzip", false},
+		{"data.bin", "application/octet-stream", false},
+	} {
+		if got := compressible(test.name, test.sniffed); got != test.want {
+			t.Fatal(test.name, got)
+		}
+	}
+}
+
+func TestGzipChecker(t *testing.T) {
+	data := []byte(strings.Repeat("hello, world\n", 1000))
+	z := gzipBytes(data)
+	for _, test := range []struct {
+		stream []byte
+		size   int64
+		ok     bool
+	}{
+		{z, int64(len(data)), true},
+		{z, -1, true},
+		{append(append([]byte{}, z...), z...), int64(len(data)) * 2, true}, // Multiple members.
+		{z, int64(len(data)) + 1, false},
+		{z, int64(len(data)) - 1, false},
+		{z[:len(z)-4], int64(len(data)), false},
+		{append(append([]byte{}, z...), "trailing"...), int64(len(data)), false},
+		{data, int64(len(data)), false},
+	} {
+		c := newGzipChecker(bytes.NewReader(test.stream), test.size)
+		got, err := io.ReadAll(c)
+		c.Close()
+		if (err == nil) != test.ok {
+			t.Fatal(test.size, err)
+		}
+		if err == nil && !bytes.Equal(got, test.stream) {
+			t.Fatal("changed stream")
+		}
+	}
+
+	// Closing stops the decompression before EOF.
+	c := newGzipChecker(bytes.NewReader(z), int64(len(data)))
+	c.Read(make([]byte, 10))
+	c.Close()
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatal("not stopped")
+	}
+}
+
+func TestRelayGzip(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	// The transport of http.DefaultClient decompresses transparently.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	text := []byte(strings.Repeat("2024-01-01 INFO something happened\n", 1000))
+	random := make([]byte, 10000)
+	rand.Read(random)
+
+	// relay sends body encoded with encoding, and receives it accepting acceptEncoding.
+	relay := func(name string, data, body []byte, encoding, acceptEncoding string) (*http.Response, []byte) {
+		tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: name, Size: int64(len(data))}}, "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tk.CtxCancel()
+		go func() {
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%v/send_file?task=%v&secret=secret&index=0", server.URL, tk.ID()), bytes.NewReader(body))
+			req.Header.Set("Content-Encoding", encoding)
+			if resp, err := http.DefaultClient.Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/r/"+tk.ID(), nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		received, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		return resp, received
+	}
+	gunzip := func(b []byte) []byte {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err = io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// Compressible files are compressed.
+	resp, received := relay("app.log", text, text, "", "gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.ContentLength == int64(len(text)) ||
+		len(received) >= len(text) || !bytes.Equal(gunzip(received), text) {
+		t.Fatal(resp.Header, len(received))
+	}
+	// Unless not accepted.
+	resp, received = relay("app.log", text, text, "", "")
+	if resp.Header.Get("Content-Encoding") != "" || resp.ContentLength != int64(len(text)) || !bytes.Equal(received, text) {
+		t.Fatal(resp.Header)
+	}
+	// Incompressible files are not compressed.
+	resp, received = relay("data.bin", random, random, "", "gzip")
+	if resp.Header.Get("Content-Encoding") != "" || resp.ContentLength != int64(len(random)) || !bytes.Equal(received, random) {
+		t.Fatal(resp.Header)
+	}
+	// Pre-compressed files are passed through.
+	z := gzipBytes(text)
+	resp, received = relay("app.log", text, z, "gzip", "gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" || !bytes.Equal(received, z) {
+		t.Fatal(resp.Header)
+	}
+	// Or decompressed.
+	resp, received = relay("app.log", text, z, "gzip", "identity")
+	if resp.Header.Get("Content-Encoding") != "" || resp.ContentLength != int64(len(text)) || !bytes.Equal(received, text) {
+		t.Fatal(resp.Header)
+	}
+
+	setConfig(t, func(c *config) { c.Gzip = false })
+	resp, received = relay("app.log", text, text, "", "gzip")
+	if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(received, text) {
+		t.Fatal(resp.Header)
+	}
+}
+
+func TestSendFileEncoding(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send_file", handleSendFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a", Size: 1}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/send_file?task="+tk.ID()+"&secret=secret&index=0", strings.NewReader("a"))
+	req.Header.Set("Content-Encoding", "br")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatal(resp.Status)
+	}
+}
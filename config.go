@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mkch/webfs/cluster"
+	"github.com/mkch/webfs/task"
+)
+
+// config is the configuration of webfs.
+//
+// The value of a setting is determined by the following sources,
+// each one overrides the previous ones:
+//  1. Default value.
+//  2. Config file (JSON), specified by -config flag or WEBFS_CONFIG environment variable.
+//  3. Environment variable, WEBFS_ followed by the upper case JSON key, e.g. WEBFS_CODE_LEN.
+//  4. Command line flag, the JSON key with "_" replaced by "-", e.g. -code-len.
+type config struct {
+	HTTP               string     `json:"http"`
+	CodeLen            int        `json:"code_len"`
+	ShowQR             bool       `json:"show_qr"`
+	TerminalQR         bool       `json:"terminal_qr"`
+	LogFormat          string     `json:"log_format"`
+	LogLevel           string     `json:"log_level"`
+	AccessLog          bool       `json:"access_log"`
+	DrainTimeout       duration   `json:"drain_timeout"`
+	IdleTimeout        duration   `json:"idle_timeout"`
+	DefaultTaskTimeout duration   `json:"default_task_timeout"`
+	MaxTaskTimeout     duration   `json:"max_task_timeout"`
+	MaxTask            int        `json:"max_task"`
+	TaskSecretLen      int        `json:"task_secret_len"`
+	TaskFailDelay      duration   `json:"task_fail_delay"`
+	AdminToken         string     `json:"admin_token"`
+	AdminHTTP          string     `json:"admin_http"`
+	RateLimit          int64      `json:"rate_limit"`
+	ClientRateLimit    int64      `json:"client_rate_limit"`
+	TaskRateLimit      int64      `json:"task_rate_limit"`
+	MaxTaskFiles       int        `json:"max_task_files"`
+	MaxTextSize        int64      `json:"max_text_size"`
+	MaxParkedUploads   int        `json:"max_parked_uploads"`
+	MaxActiveRelays    int        `json:"max_active_relays"`
+	MaxWaitingRelays   int        `json:"max_waiting_relays"`
+	MaxClientRequests  int        `json:"max_client_requests"`
+	BasePath           string     `json:"base_path"`
+	TrustedProxies     stringList `json:"trusted_proxies"`
+	ThemeDir           string     `json:"theme_dir"`
+	SiteTitle          string     `json:"site_title"`
+	Footer             string     `json:"footer"`
+	Gzip               bool       `json:"gzip"`
+	P2P                bool       `json:"p2p"`
+	ICEServers         stringList `json:"ice_servers"`
+	MDNS               bool       `json:"mdns"`
+	MDNSName           string     `json:"mdns_name"`
+	ClusterNodes       stringList `json:"cluster_nodes"`
+	ClusterSelf        string     `json:"cluster_self"`
+}
+
+// stringList is a list of strings, comma separated in flags and
+// environment variables.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = nil
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// duration is a time.Duration in the format of time.ParseDuration in JSON.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+const DefaultServeAddr = ":8080"
+
+const DefaultIDLen = 3
+const MaxIDLen = 64
+
+const minTaskSecretLen = 8
+
+func defaultConfig() config {
+	return config{
+		HTTP:               DefaultServeAddr,
+		CodeLen:            DefaultIDLen,
+		TerminalQR:         true,
+		LogFormat:          "text",
+		LogLevel:           "info",
+		DrainTimeout:       duration(time.Second * 30),
+		DefaultTaskTimeout: duration(time.Minute * 10),
+		MaxTaskTimeout:     duration(time.Minute * 30),
+		MaxTask:            task.DefaultMaxTask,
+		TaskSecretLen:      16,
+		TaskFailDelay:      duration(time.Second * 2),
+		MaxTaskFiles:       1000,
+		MaxTextSize:        64 * 1024,
+		Gzip:               true,
+		P2P:                true,
+	}
+}
+
+// bindFlags defines flags in fs that set the fields of c.
+func bindFlags(fs *flag.FlagSet, c *config) {
+	fs.StringVar(&c.HTTP, "http", c.HTTP, "HTTP service address")
+	fs.IntVar(&c.CodeLen, "code-len", c.CodeLen, fmt.Sprintf("Length of the task code, [%v,%v]", DefaultIDLen, MaxIDLen))
+	fs.BoolVar(&c.ShowQR, "show-qr", c.ShowQR, "Show QR code of downloading URL in sending page")
+	fs.BoolVar(&c.TerminalQR, "terminal-qr", c.TerminalQR, "Print QR code of the server URL when started in a terminal")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "Log format, text or json")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "Log level, debug, info, warn or error")
+	fs.BoolVar(&c.AccessLog, "access-log", c.AccessLog, "Log every HTTP request")
+	fs.DurationVar((*time.Duration)(&c.DrainTimeout), "drain-timeout", time.Duration(c.DrainTimeout), fmt.Sprintf("Time to wait for active transfers when shutting down, before the remaining ones are given up to %v more to stop", shutdownGrace))
+	fs.DurationVar((*time.Duration)(&c.IdleTimeout), "idle-timeout", time.Duration(c.IdleTimeout), "Shut down after no task exists for this long, 0 to never shut down")
+	fs.DurationVar((*time.Duration)(&c.DefaultTaskTimeout), "default-task-timeout", time.Duration(c.DefaultTaskTimeout), "Timeout of a task if not specified by the sender")
+	fs.DurationVar((*time.Duration)(&c.MaxTaskTimeout), "max-task-timeout", time.Duration(c.MaxTaskTimeout), "Max timeout of a task")
+	fs.IntVar(&c.MaxTask, "max-task", c.MaxTask, "Max number of tasks")
+	fs.IntVar(&c.TaskSecretLen, "task-secret-len", c.TaskSecretLen, fmt.Sprintf("Length of the task secret, at least %v", minTaskSecretLen))
+	fs.DurationVar((*time.Duration)(&c.TaskFailDelay), "task-fail-delay", time.Duration(c.TaskFailDelay), "Delay of responding unknown task code or wrong secret")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "Token to access the admin area, admin area is disabled if empty")
+	fs.StringVar(&c.AdminHTTP, "admin-http", c.AdminHTTP, "HTTP service address of the admin area, the same as -http if empty")
+	fs.Int64Var(&c.RateLimit, "rate-limit", c.RateLimit, "Max total relay rate in bytes per second, 0 if unlimited")
+	fs.Int64Var(&c.ClientRateLimit, "client-rate-limit", c.ClientRateLimit, "Max relay rate of a client IP in bytes per second, 0 if unlimited")
+	fs.Int64Var(&c.TaskRateLimit, "task-rate-limit", c.TaskRateLimit, "Max relay rate of a task in bytes per second, 0 if unlimited")
+	fs.IntVar(&c.MaxTaskFiles, "max-task-files", c.MaxTaskFiles, "Max number of files in a task, 0 if unlimited")
+	fs.Int64Var(&c.MaxTextSize, "max-text-size", c.MaxTextSize, "Max size of the text of a text task in bytes")
+	fs.IntVar(&c.MaxParkedUploads, "max-parked-uploads", c.MaxParkedUploads, "Max number of uploads waiting for receivers, 0 if unlimited")
+	fs.IntVar(&c.MaxActiveRelays, "max-active-relays", c.MaxActiveRelays, "Max number of receivers downloading files, 0 if unlimited")
+	fs.IntVar(&c.MaxWaitingRelays, "max-waiting-relays", c.MaxWaitingRelays, "Max number of receivers waiting for senders, 0 if unlimited")
+	fs.IntVar(&c.MaxClientRequests, "max-client-requests", c.MaxClientRequests, "Max number of concurrent requests of a client IP, 0 if unlimited")
+	fs.StringVar(&c.BasePath, "base-path", c.BasePath, "Path prefix of all pages, e.g. /webfs")
+	fs.Var(&c.TrustedProxies, "trusted-proxies", "Comma separated IPs or CIDRs of proxies whose X-Forwarded-* headers are trusted")
+	fs.StringVar(&c.ThemeDir, "theme-dir", c.ThemeDir, "Directory of static files and templates overriding the builtin ones")
+	fs.StringVar(&c.SiteTitle, "site-title", c.SiteTitle, "Title of the site, a localized default if empty")
+	fs.StringVar(&c.Footer, "footer", c.Footer, "Text in the footer of all pages")
+	fs.BoolVar(&c.Gzip, "gzip", c.Gzip, "Compress relays of compressible files for receivers accepting gzip")
+	fs.BoolVar(&c.P2P, "p2p", c.P2P, "Transfer files peer to peer with WebRTC when possible")
+	fs.Var(&c.ICEServers, "ice-servers", "Comma separated STUN or TURN server URLs for peer to peer transfers")
+	fs.BoolVar(&c.MDNS, "mdns", c.MDNS, "Advertise the server on the local network with mDNS")
+	fs.StringVar(&c.MDNSName, "mdns-name", c.MDNSName, `Name of the server advertised with mDNS, "webfs on <hostname>" if empty`)
+	fs.Var(&c.ClusterNodes, "cluster-nodes", "Comma separated base URLs of all nodes of the cluster, e.g. http://10.0.0.1:8080")
+	fs.StringVar(&c.ClusterSelf, "cluster-self", c.ClusterSelf, "Base URL of this node in cluster-nodes")
+}
+
+// jsonKeys returns the JSON keys of all fields of config.
+func jsonKeys() (keys []string) {
+	t := reflect.TypeOf(config{})
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, t.Field(i).Tag.Get("json"))
+	}
+	return
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+func envName(key string) string {
+	return "WEBFS_" + strings.ToUpper(key)
+}
+
+const configEnv = "WEBFS_CONFIG"
+
+// loadConfig loads the config from the config file, environment variables
+// and command line args. See config for the precedence.
+// name is the program name used in the usage message.
+func loadConfig(name string, args []string, output io.Writer) (*config, error) {
+	// Parse the command line args first to get the config file path
+	// and the set of flags specified explicitly.
+	var flagConfig = defaultConfig()
+	var configFile = os.Getenv(configEnv)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	bindFlags(fs, &flagConfig)
+	fs.StringVar(&configFile, "config", configFile, fmt.Sprintf("Config file in JSON, also %v environment variable", configEnv))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %v:\n", name)
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nAll settings but -config can also be set in the config file "+
+			"with the JSON key and in environment variables with WEBFS_ prefix, e.g. code_len and WEBFS_CODE_LEN.\n"+
+			"Flags override environment variables, which override the config file.\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	c := defaultConfig()
+	if configFile != "" {
+		if err := loadConfigFile(configFile, &c); err != nil {
+			return nil, err
+		}
+	}
+
+	// Use a flag set to parse environment variables the same way as flags.
+	setter := flag.NewFlagSet(name, flag.ContinueOnError)
+	setter.SetOutput(io.Discard)
+	bindFlags(setter, &c)
+	for _, key := range jsonKeys() {
+		env := envName(key)
+		if v, ok := os.LookupEnv(env); ok {
+			if err := setter.Set(flagName(key), v); err != nil {
+				return nil, fmt.Errorf("invalid %v: %w", env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || err != nil {
+			return
+		}
+		err = setter.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// loadConfigFile loads the JSON config file into c.
+// Only the keys in the file are changed.
+func loadConfigFile(file string, c *config) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config file %v: %w", file, err)
+	}
+	return nil
+}
+
+// validate checks all settings of c.
+func (c *config) validate() error {
+	var errs []error
+	check := func(ok bool, key string, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid %v (-%v, %v): %v", key, flagName(key), envName(key), fmt.Sprintf(format, args...)))
+		}
+	}
+	check(c.HTTP != "", "http", "empty address")
+	check(c.CodeLen >= DefaultIDLen && c.CodeLen <= MaxIDLen, "code_len", "%v is not in [%v,%v]", c.CodeLen, DefaultIDLen, MaxIDLen)
+	check(c.LogFormat == "text" || c.LogFormat == "json", "log_format", "%q is not text or json", c.LogFormat)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log_level", "%q is not debug, info, warn or error", c.LogLevel)
+	check(c.DrainTimeout >= 0, "drain_timeout", "negative duration %v", time.Duration(c.DrainTimeout))
+	check(c.IdleTimeout >= 0, "idle_timeout", "negative duration %v", time.Duration(c.IdleTimeout))
+	check(c.DefaultTaskTimeout > 0, "default_task_timeout", "%v is not positive", time.Duration(c.DefaultTaskTimeout))
+	check(c.MaxTaskTimeout >= c.DefaultTaskTimeout, "max_task_timeout", "%v is less than default_task_timeout %v",
+		time.Duration(c.MaxTaskTimeout), time.Duration(c.DefaultTaskTimeout))
+	check(c.MaxTask > 0, "max_task", "%v is not positive", c.MaxTask)
+	check(c.TaskSecretLen >= minTaskSecretLen, "task_secret_len", "%v is less than %v", c.TaskSecretLen, minTaskSecretLen)
+	check(c.TaskFailDelay >= 0, "task_fail_delay", "negative duration %v", time.Duration(c.TaskFailDelay))
+	check(c.RateLimit >= 0, "rate_limit", "%v is negative", c.RateLimit)
+	check(c.ClientRateLimit >= 0, "client_rate_limit", "%v is negative", c.ClientRateLimit)
+	check(c.TaskRateLimit >= 0, "task_rate_limit", "%v is negative", c.TaskRateLimit)
+	check(c.MaxTaskFiles >= 0, "max_task_files", "%v is negative", c.MaxTaskFiles)
+	check(c.MaxTextSize > 0, "max_text_size", "%v is not positive", c.MaxTextSize)
+	check(c.MaxParkedUploads >= 0, "max_parked_uploads", "%v is negative", c.MaxParkedUploads)
+	check(c.MaxActiveRelays >= 0, "max_active_relays", "%v is negative", c.MaxActiveRelays)
+	check(c.MaxWaitingRelays >= 0, "max_waiting_relays", "%v is negative", c.MaxWaitingRelays)
+	check(c.MaxClientRequests >= 0, "max_client_requests", "%v is negative", c.MaxClientRequests)
+	check(c.BasePath == "" || (strings.HasPrefix(c.BasePath, "/") && !strings.HasSuffix(c.BasePath, "/") &&
+		!strings.ContainsAny(c.BasePath, "?#") && path.Clean(c.BasePath) == c.BasePath),
+		"base_path", "%q is not a clean path starting but not ending with /", c.BasePath)
+	for _, p := range c.TrustedProxies {
+		_, err := parsePrefix(p)
+		check(err == nil, "trusted_proxies", "%q is not an IP or CIDR", p)
+	}
+	if c.ThemeDir != "" {
+		fi, err := os.Stat(c.ThemeDir)
+		check(err == nil && fi.IsDir(), "theme_dir", "%q is not a directory", c.ThemeDir)
+	}
+	for _, s := range c.ICEServers {
+		check(strings.HasPrefix(s, "stun:") || strings.HasPrefix(s, "stuns:") ||
+			strings.HasPrefix(s, "turn:") || strings.HasPrefix(s, "turns:"),
+			"ice_servers", "%q is not a stun:, stuns:, turn: or turns: URL", s)
+	}
+	check(len(c.MDNSName) <= 63, "mdns_name", "%q is longer than 63 bytes", c.MDNSName)
+	if len(c.ClusterNodes) > 0 {
+		_, err := cluster.New(c.ClusterNodes, c.ClusterSelf)
+		check(err == nil, "cluster_nodes", "%v", err)
+	} else {
+		check(c.ClusterSelf == "", "cluster_self", "requires cluster_nodes")
+	}
+	check(c.AdminHTTP == "" || c.AdminToken != "", "admin_token", "required by admin_http")
+	check(c.AdminHTTP == "" || c.AdminHTTP != c.HTTP, "admin_http", "the same as http")
+	return errors.Join(errs...)
+}
+
+// currentConfig is the effective config.
+var currentConfig atomic.Pointer[config]
+
+func init() {
+	c := defaultConfig()
+	currentConfig.Store(&c)
+}
+
+// getConfig returns the effective config.
+// The returned value must not be modified.
+func getConfig() *config {
+	return currentConfig.Load()
+}
+
+// applyConfig makes c the effective config.
+func applyConfig(c *config) {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.LogLevel))
+	logLevel.Set(level)
+	task.SetMaxTask(c.MaxTask)
+	globalBucket.SetRate(c.RateLimit)
+	clientBuckets.SetRate(c.ClientRateLimit)
+	currentConfig.Store(c)
+}
+
+// reloadConfig reloads the config and applies the settings that can be
+// changed without restarting.
+func reloadConfig(args []string) {
+	c, err := loadConfig("webfs", args, io.Discard)
+	if err != nil {
+		slog.Error("failed to reload config", "error", err)
+		return
+	}
+	old := getConfig()
+	// These settings can't be changed without restarting.
+	if c.HTTP != old.HTTP {
+		slog.Warn("http can't be changed without restarting", "http", old.HTTP)
+		c.HTTP = old.HTTP
+	}
+	if c.AdminHTTP != old.AdminHTTP {
+		slog.Warn("admin_http can't be changed without restarting", "admin_http", old.AdminHTTP)
+		c.AdminHTTP = old.AdminHTTP
+	}
+	if c.BasePath != old.BasePath {
+		slog.Warn("base_path can't be changed without restarting", "base_path", old.BasePath)
+		c.BasePath = old.BasePath
+	}
+	if c.MDNS != old.MDNS || c.MDNSName != old.MDNSName {
+		slog.Warn("mdns can't be changed without restarting", "mdns", old.MDNS, "mdns_name", old.MDNSName)
+		c.MDNS, c.MDNSName = old.MDNS, old.MDNSName
+	}
+	if c.ClusterNodes.String() != old.ClusterNodes.String() || c.ClusterSelf != old.ClusterSelf {
+		slog.Warn("cluster can't be changed without restarting", "cluster_nodes", old.ClusterNodes.String(), "cluster_self", old.ClusterSelf)
+		c.ClusterNodes, c.ClusterSelf = old.ClusterNodes, old.ClusterSelf
+	}
+	if c.LogFormat != old.LogFormat {
+		slog.Warn("log_format can't be changed without restarting", "log_format", old.LogFormat)
+		c.LogFormat = old.LogFormat
+	}
+	// Templates are parsed again even if theme_dir is not changed,
+	// so that the changes of the theme take effect.
+	if theme, err := loadTheme(c.ThemeDir); err != nil {
+		slog.Error("failed to reload theme", "theme_dir", c.ThemeDir, "error", err)
+		c.ThemeDir = old.ThemeDir
+	} else {
+		currentTheme.Store(theme)
+	}
+	applyConfig(c)
+	slog.Info("config reloaded")
+}
+
+// runConfig runs the "config" sub command.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "Usage: webfs config print [flags]")
+		return 2
+	}
+	c, err := loadConfig("webfs config print", args[1:], os.Stderr)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if c.AdminToken != "" {
+		c.AdminToken = "<hidden>"
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setConfig makes a modified copy of the effective config effective
+// during the test.
+func setConfig(t *testing.T, modify func(c *config)) {
+	old := getConfig()
+	c := *old
+	modify(&c)
+	currentConfig.Store(&c)
+	t.Cleanup(func() { currentConfig.Store(old) })
+}
+
+func TestLoadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "webfs.json")
+	if err := os.WriteFile(file, []byte(`{"code_len": 5, "show_qr": true, "max_task": 10, "task_fail_delay": "1s"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(configEnv, file)
+	t.Setenv("WEBFS_CODE_LEN", "6")
+	t.Setenv("WEBFS_MAX_TASK", "20")
+
+	c, err := loadConfig("webfs", []string{"-code-len", "7", "-default-task-timeout", "1m"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CodeLen != 7 { // Flag overrides environment variable.
+		t.Fatal(c.CodeLen)
+	}
+	if c.MaxTask != 20 { // Environment variable overrides config file.
+		t.Fatal(c.MaxTask)
+	}
+	if !c.ShowQR || c.TaskFailDelay != duration(time.Second) { // Config file overrides default.
+		t.Fatal(c.ShowQR, c.TaskFailDelay)
+	}
+	if c.DefaultTaskTimeout != duration(time.Minute) {
+		t.Fatal(c.DefaultTaskTimeout)
+	}
+	if def := defaultConfig(); c.HTTP != def.HTTP || c.TaskSecretLen != def.TaskSecretLen {
+		t.Fatal(c)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Setenv(configEnv, "")
+
+	if _, err := loadConfig("webfs", []string{"-code-len", "2"}, io.Discard); err == nil || !strings.Contains(err.Error(), "code_len") {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig("webfs", []string{"-default-task-timeout", "1h"}, io.Discard); err == nil || !strings.Contains(err.Error(), "max_task_timeout") {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig("webfs", []string{"-ice-servers", "stun:a.example.com,http://b.example.com"}, io.Discard); err == nil || !strings.Contains(err.Error(), "ice_servers") {
+		t.Fatal(err)
+	}
+
+	if _, err := loadConfig("webfs", []string{"-mdns-name", strings.Repeat("a", 64)}, io.Discard); err == nil || !strings.Contains(err.Error(), "mdns_name") {
+		t.Fatal(err)
+	}
+
+	if _, err := loadConfig("webfs", []string{"-cluster-nodes", "http://a:8080,http://b:8080", "-cluster-self", "http://c:8080"}, io.Discard); err == nil || !strings.Contains(err.Error(), "cluster_nodes") {
+		t.Fatal(err)
+	}
+
+	t.Setenv("WEBFS_SHOW_QR", "maybe")
+	if _, err := loadConfig("webfs", nil, io.Discard); err == nil || !strings.Contains(err.Error(), "WEBFS_SHOW_QR") {
+		t.Fatal(err)
+	}
+	os.Unsetenv("WEBFS_SHOW_QR")
+
+	file := filepath.Join(t.TempDir(), "webfs.json")
+	if err := os.WriteFile(file, []byte(`{"unknown": 1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig("webfs", []string{"-config", file}, io.Discard); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigJSON(t *testing.T) {
+	c := defaultConfig()
+	b, err := json.Marshal(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"default_task_timeout":"10m0s"`) {
+		t.Fatal(string(b))
+	}
+	var c2 config
+	if err := json.Unmarshal(b, &c2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c2, c) {
+		t.Fatal(c2)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mkch/webfs/mdns"
+)
+
+// Service types advertised with mDNS. _webfs._tcp is browsed by the CLI,
+// _http._tcp shows the server in generic browsers of the local network.
+const (
+	mdnsServiceType = "_webfs._tcp"
+	mdnsHTTPType    = "_http._tcp"
+)
+
+// sendDiscoverTimeout is the time to discover a server when the send sub
+// command is run without one.
+var sendDiscoverTimeout = time.Second * 2
+
+// browseServers discovers the servers on the local network until ctx is done.
+var browseServers = func(ctx context.Context) ([]mdns.Service, error) {
+	return mdns.Browse(ctx, mdnsServiceType)
+}
+
+// advertise advertises the server listening on l with mDNS until ctx is done.
+func advertise(ctx context.Context, c *config, l net.Listener) {
+	addr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		slog.Warn("mdns is not supported on the listener", "addr", l.Addr())
+		return
+	}
+	ips := mdns.LocalIPs()
+	if !addr.IP.IsUnspecified() {
+		if addr.IP.To4() == nil || addr.IP.IsLoopback() {
+			slog.Warn("mdns is not supported on the address", "addr", addr)
+			return
+		}
+		ips = []net.IP{addr.IP.To4()}
+	}
+	host := mdns.HostName()
+	name := c.MDNSName
+	if name == "" {
+		name = "webfs on " + host
+	}
+	txt := []string{
+		"version=" + buildVersion().Version,
+		// The server only serves plain HTTP, TLS is terminated by a proxy if any.
+		"tls=false",
+		"path=" + c.BasePath + "/",
+	}
+	var services []mdns.Service
+	for _, typ := range []string{mdnsServiceType, mdnsHTTPType} {
+		services = append(services, mdns.Service{
+			Instance: name, Type: typ, Host: host, Port: addr.Port, IPs: ips, TXT: txt})
+	}
+	slog.Info("advertising with mdns", "name", name, "host", host+".local", "port", addr.Port)
+	if err := mdns.Advertise(ctx, services...); err != nil {
+		slog.Error("failed to advertise with mdns", "error", err)
+	}
+}
+
+// discoveredServer is a server discovered on the local network.
+type discoveredServer struct {
+	Name    string
+	URL     string // Without trailing slash.
+	Version string
+}
+
+// discoverServers discovers the servers on the local network for timeout.
+func discoverServers(ctx context.Context, timeout time.Duration) ([]discoveredServer, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	services, err := browseServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var servers []discoveredServer
+	for _, s := range services {
+		if len(s.IPs) == 0 {
+			continue
+		}
+		scheme := "http"
+		if s.TXTValue("tls") == "true" {
+			scheme = "https"
+		}
+		u := url.URL{
+			Scheme: scheme,
+			Host:   net.JoinHostPort(s.IPs[0].String(), strconv.Itoa(s.Port)),
+			Path:   strings.TrimSuffix(s.TXTValue("path"), "/"),
+		}
+		servers = append(servers, discoveredServer{s.Instance, u.String(), s.TXTValue("version")})
+	}
+	return servers, nil
+}
+
+// printServers prints servers in a table.
+func printServers(w io.Writer, servers []discoveredServer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tURL\tVERSION")
+	for _, s := range servers {
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", s.Name, s.URL, s.Version)
+	}
+	tw.Flush()
+}
+
+// defaultServer returns the server of the send sub command if not given.
+// A server discovered on the local network is used if there is only one,
+// localhost otherwise.
+func defaultServer(ctx context.Context, stderr io.Writer) (string, error) {
+	servers, err := discoverServers(ctx, sendDiscoverTimeout)
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to discover servers:", err)
+	}
+	switch len(servers) {
+	case 0:
+		return "http://localhost" + DefaultServeAddr, nil
+	case 1:
+		fmt.Fprintf(stderr, "Using %v at %v\n", servers[0].Name, servers[0].URL)
+		return servers[0].URL, nil
+	default:
+		printServers(stderr, servers)
+		return "", fmt.Errorf("%v servers found, choose one with -server", len(servers))
+	}
+}
+
+// runDiscover runs the "discover" sub command.
+func runDiscover(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("webfs discover", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	timeout := fs.Duration("timeout", time.Second*3, "Time to wait for the responses")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: webfs discover [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	servers, err := discoverServers(ctx, *timeout)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if len(servers) == 0 {
+		fmt.Fprintln(stderr, "No server found")
+		return 1
+	}
+	printServers(stdout, servers)
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mkch/webfs/mdns"
+)
+
+// setBrowseServers makes browseServers return services in test t.
+func setBrowseServers(t *testing.T, services ...mdns.Service) {
+	old := browseServers
+	browseServers = func(ctx context.Context) ([]mdns.Service, error) { return services, nil }
+	t.Cleanup(func() { browseServers = old })
+}
+
+func TestDiscover(t *testing.T) {
+	setBrowseServers(t,
+		mdns.Service{Instance: "webfs on a", Port: 8080, IPs: []net.IP{net.IPv4(192, 168, 1, 2)},
+			TXT: []string{"version=v1.2.0", "tls=false", "path=/"}},
+		mdns.Service{Instance: "webfs on b", Port: 443, IPs: []net.IP{net.IPv4(192, 168, 1, 3)},
+			TXT: []string{"version=v1.3.0", "tls=true", "path=/webfs/"}},
+		mdns.Service{Instance: "no address", Port: 80},
+	)
+	var stdout bytes.Buffer
+	if exit := runDiscover([]string{"-timeout", "1ms"}, &stdout, io.Discard); exit != 0 {
+		t.Fatal(exit)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 ||
+		strings.Join(strings.Fields(lines[1]), " ") != "webfs on a http://192.168.1.2:8080 v1.2.0" ||
+		strings.Join(strings.Fields(lines[2]), " ") != "webfs on b https://192.168.1.3:443/webfs v1.3.0" {
+		t.Fatal(stdout.String())
+	}
+
+	setBrowseServers(t)
+	if exit := runDiscover([]string{"-timeout", "1ms"}, io.Discard, io.Discard); exit != 1 {
+		t.Fatal(exit)
+	}
+	if exit := runDiscover([]string{"extra"}, io.Discard, io.Discard); exit != 2 {
+		t.Fatal(exit)
+	}
+}
+
+func TestSendDiscover(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
+	server := newSendServer(t)
+	defer server.Close()
+	t.Setenv(serverEnv, "")
+	addr := server.Listener.Addr().(*net.TCPAddr)
+
+	// The only server found is used.
+	setBrowseServers(t, mdns.Service{Instance: "test", Port: addr.Port, IPs: []net.IP{addr.IP}, TXT: []string{"path=/"}})
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	if exit := runSend([]string{"-qr=false", "-text", "hello"}, stdout, stderr); exit != 0 {
+		t.Fatal(exit, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "Using test at "+server.URL) {
+		t.Fatal(stderr.String())
+	}
+
+	// Several servers must be chosen from.
+	setBrowseServers(t,
+		mdns.Service{Instance: "a", Port: 1, IPs: []net.IP{net.IPv4(127, 0, 0, 1)}},
+		mdns.Service{Instance: "b", Port: 2, IPs: []net.IP{net.IPv4(127, 0, 0, 1)}},
+	)
+	stderr = &syncBuffer{}
+	if exit := runSend([]string{"-qr=false", "-text", "hello"}, io.Discard, stderr); exit != 1 {
+		t.Fatal(exit)
+	}
+	if !strings.Contains(stderr.String(), "2 servers found") {
+		t.Fatal(stderr.String())
+	}
+}
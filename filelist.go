@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+// fileListItem is a file shown in the file list.
+type fileListItem struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	Icon        string `json:"icon"`
+	Previewable bool   `json:"previewable"`
+	// Status is "available" if the sender is uploading the file or accepts
+	// peer to peer transfers, "transferring" if someone is receiving it
+	// from the relay, or "waiting".
+	Status    string `json:"status"`
+	Downloads int    `json:"downloads"` // Number of times the file is received.
+}
+
+// fileListStatus is the state of a task shown in the file list.
+type fileListStatus struct {
+	Remaining    int64          `json:"remaining"`     // Remaining lifetime of the task in seconds.
+	SenderOnline bool           `json:"sender_online"` // Whether any file is being uploaded.
+	P2P          bool           `json:"p2p"`           // Whether files can be received peer to peer.
+	TotalSize    int64          `json:"total_size"`    // -1 if the size of any file is unknown.
+	Files        []fileListItem `json:"files"`
+}
+
+// newFileListStatus returns the current state of task t.
+func newFileListStatus(t *task.Task) *fileListStatus {
+	s := &fileListStatus{
+		Remaining: max(int64(time.Until(t.Deadline())/time.Second), 0),
+		Files:     make([]fileListItem, t.NFiles()),
+		P2P:       p2pAvailable(t),
+	}
+	s.SenderOnline = s.P2P
+	for i := range s.Files {
+		file := t.File(i)
+		info, status := file.Info(), file.Status()
+		item := fileListItem{
+			Index:       i,
+			Name:        info.Name,
+			Size:        info.Size,
+			Icon:        fileIcon(info.Name),
+			Previewable: previewKind(info.Name) != "",
+			Status:      "waiting",
+			Downloads:   status.Downloads,
+		}
+		switch status.State {
+		case task.FileWaiting:
+			if s.P2P {
+				item.Status = "available"
+			}
+		case task.FileParked:
+			item.Status = "available"
+			s.SenderOnline = true
+		case task.FileTransferring:
+			item.Status = "transferring"
+			s.SenderOnline = true
+		}
+		s.Files[i] = item
+		if info.Size < 0 || s.TotalSize < 0 {
+			s.TotalSize = -1
+		} else {
+			s.TotalSize += info.Size
+		}
+	}
+	return s
+}
+
+// archiveExts are the extensions of archive files.
+var archiveExts = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".tar": true, ".bz2": true,
+	".xz": true, ".zst": true, ".7z": true, ".rar": true,
+}
+
+// fileIcon returns the icon of a file by its name.
+func fileIcon(name string) string {
+	if archiveExts[strings.ToLower(path.Ext(name))] {
+		return "📦"
+	}
+	switch t := nameType(name); {
+	case strings.HasPrefix(t, "image/"):
+		return "🖼️"
+	case strings.HasPrefix(t, "audio/"):
+		return "🎵"
+	case strings.HasPrefix(t, "video/"), t == "application/ogg":
+		return "🎬"
+	case t == "application/pdf":
+		return "📕"
+	case strings.HasPrefix(t, "text/"):
+		return "📝"
+	}
+	return "📄"
+}
+
+// formatSize returns size in human readable units.
+func formatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	f, i := float64(size), 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%v %v", size, units[0])
+	}
+	return fmt.Sprintf("%.1f %v", f, units[i])
+}
+
+// serveFileList renders the file list of task t, or responds its state
+// in JSON if the status query parameter is present.
+func serveFileList(w http.ResponseWriter, r *http.Request, t *task.Task) {
+	status := newFileListStatus(t)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Has("status") {
+		writeJSON(w, r, status)
+		return
+	}
+	renderPage(w, r, "file_list.html", &struct {
+		page
+		ID     string
+		Status *fileListStatus
+	}{newPage(r), t.ID(), status})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+func TestFileIcon(t *testing.T) {
+	for name, icon := range map[string]string{
+		"a.PNG":      "🖼️",
+		"a.mp3":      "🎵",
+		"a.webm":     "🎬",
+		"a.pdf":      "📕",
+		"a.txt":      "📝",
+		"a.tar.gz":   "📦",
+		"a.zip":      "📦",
+		"a":          "📄",
+		"a.unknown1": "📄",
+	} {
+		if got := fileIcon(name); got != icon {
+			t.Errorf("%v: %v", name, got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for size, s := range map[int64]string{
+		0:                  "0 B",
+		1023:               "1023 B",
+		1024:               "1.0 KB",
+		1536:               "1.5 KB",
+		5 * 1024 * 1024:    "5.0 MB",
+		3 << 40:            "3.0 TB",
+		1 << 50:            "1024.0 TB",
+		1024*1024*1024 - 1: "1024.0 MB",
+	} {
+		if got := formatSize(size); got != s {
+			t.Errorf("%v: %v", size, got)
+		}
+	}
+}
+
+func TestFileList(t *testing.T) {
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{
+		{Name: "a.png", Size: 10}, {Name: "b.zip", Size: 2048}, {Name: "c", Size: 1},
+	}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+
+	tk.File(0).Park("127.0.0.2")
+	tk.File(1).Park("127.0.0.2")
+	tk.File(1).StartTransfer("127.0.0.3")
+	tk.File(1).FinishTransfer(nil)
+	tk.File(2).Park("127.0.0.2")
+	tk.File(2).StartTransfer("127.0.0.3")
+
+	w := httptest.NewRecorder()
+	handleReceiveFile(w, httptest.NewRequest("GET", "/r/"+tk.ID()+"?status", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatal(w.Code, w.Header())
+	}
+	var status fileListStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Remaining <= 0 || status.Remaining > 60 || !status.SenderOnline || status.TotalSize != 2059 || len(status.Files) != 3 {
+		t.Fatal(status)
+	}
+	for i, want := range []fileListItem{
+		{Index: 0, Name: "a.png", Size: 10, Icon: "🖼️", Previewable: true, Status: "available"},
+		{Index: 1, Name: "b.zip", Size: 2048, Icon: "📦", Status: "waiting", Downloads: 1},
+		{Index: 2, Name: "c", Size: 1, Icon: "📄", Status: "transferring"},
+	} {
+		if status.Files[i] != want {
+			t.Errorf("%v: %+v", i, status.Files[i])
+		}
+	}
+
+	tk.File(0).Unpark()
+	tk.File(2).FinishTransfer(errors.New("failed"))
+	if status := newFileListStatus(tk); status.SenderOnline {
+		t.Fatal(status)
+	}
+
+	r := httptest.NewRequest("GET", "/r/"+tk.ID(), nil)
+	r.Header.Set("Accept-Language", "zh")
+	w = httptest.NewRecorder()
+	handleReceiveFile(w, r)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, "3 个文件，2.0 KB") || !strings.Contains(body, `href="?index=1"`) ||
+		!strings.Contains(body, "2.0 KB") || !strings.Contains(body, "全部下载") || !strings.Contains(body, `"status":"waiting"`) {
+		t.Fatal(w.Code, body)
+	}
+}
+
+func TestFileListUnknownSize(t *testing.T) {
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{
+		{Name: "a", Size: 10}, {Name: "b", Size: -1}, {Name: "c", Size: 20},
+	}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+
+	if status := newFileListStatus(tk); status.TotalSize != -1 {
+		t.Fatal(status.TotalSize)
+	}
+	r := httptest.NewRequest("GET", "/r/"+tk.ID(), nil)
+	r.Header.Set("Accept-Language", "zh")
+	w := httptest.NewRecorder()
+	handleReceiveFile(w, r)
+	if body := w.Body.String(); !strings.Contains(body, "3 个文件，大小未知") || strings.Contains(body, "-1 B") {
+		t.Fatal(body)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/mkch/webfs/task"
+)
+
+// probePaths are the paths of probe endpoints.
+// Requests of them are not access logged.
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/version": true,
+}
+
+// isProbe returns whether r is a request of probe endpoints.
+func isProbe(r *http.Request) bool {
+	return probePaths[strings.TrimPrefix(r.URL.Path, getConfig().BasePath)]
+}
+
+// handleHealthz responds OK if the process is alive.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz responds OK if the server is accepting new tasks.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	if n, max := task.Count(), getConfig().MaxTask; n >= max {
+		http.Error(w, fmt.Sprintf("too many tasks: %v/%v", n, max), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// versionInfo is the response of /version.
+type versionInfo struct {
+	Version   string   `json:"version"`            // Module version.
+	GoVersion string   `json:"go_version"`         // Version of the Go toolchain.
+	Revision  string   `json:"revision,omitempty"` // VCS revision.
+	Time      string   `json:"time,omitempty"`     // VCS commit time.
+	Modified  bool     `json:"modified,omitempty"` // Whether the source tree has local modifications.
+	Features  []string `json:"features"`           // Enabled optional features.
+}
+
+// buildVersion returns the version information from the build info.
+func buildVersion() *versionInfo {
+	v := &versionInfo{Version: "(devel)", Features: []string{}}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.Version = info.Main.Version
+	v.GoVersion = info.GoVersion
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Revision = s.Value
+		case "vcs.time":
+			v.Time = s.Value
+		case "vcs.modified":
+			v.Modified = s.Value == "true"
+		}
+	}
+	return v
+}
+
+// enabledFeatures returns the optional features enabled by c.
+func enabledFeatures(c *config) (features []string) {
+	// The WebSocket upload transport is always available.
+	features = []string{"metrics", "websocket"}
+	if c.AdminToken != "" {
+		features = append(features, "admin")
+	}
+	if c.AccessLog {
+		features = append(features, "access_log")
+	}
+	if c.ShowQR {
+		features = append(features, "show_qr")
+	}
+	if c.RateLimit > 0 || c.ClientRateLimit > 0 || c.TaskRateLimit > 0 {
+		features = append(features, "rate_limit")
+	}
+	if c.MaxParkedUploads > 0 || c.MaxActiveRelays > 0 ||
+		c.MaxWaitingRelays > 0 || c.MaxClientRequests > 0 {
+		features = append(features, "limits")
+	}
+	if c.ThemeDir != "" {
+		features = append(features, "theme")
+	}
+	if c.P2P {
+		features = append(features, "p2p")
+	}
+	if c.MDNS {
+		features = append(features, "mdns")
+	}
+	if len(c.ClusterNodes) > 0 {
+		features = append(features, "cluster")
+	}
+	if c.Gzip {
+		features = append(features, "gzip")
+	}
+	return
+}
+
+// handleVersion responds the version information in JSON.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	v := buildVersion()
+	v.Features = enabledFeatures(getConfig())
+	writeJSON(w, r, v)
+}
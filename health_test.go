@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+
+	setConfig(t, func(c *config) { c.MaxTask = 0 })
+	w = httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestReadyzDraining(t *testing.T) {
+	draining.Store(true)
+	defer draining.Store(false)
+	w := httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	setConfig(t, func(c *config) { c.AdminToken = "token" })
+	w := httptest.NewRecorder()
+	handleVersion(w, httptest.NewRequest("GET", "/version", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+	var v versionInfo
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Version == "" {
+		t.Fatal(v)
+	}
+	hasAdmin := false
+	for _, f := range v.Features {
+		hasAdmin = hasAdmin || f == "admin"
+	}
+	if !hasAdmin {
+		t.Fatal(v.Features)
+	}
+}
+
+func TestEnabledFeatures(t *testing.T) {
+	c := defaultConfig()
+	// Every optional feature off.
+	c.AdminToken = ""
+	c.AccessLog = false
+	c.ShowQR = false
+	c.RateLimit, c.ClientRateLimit, c.TaskRateLimit = 0, 0, 0
+	c.MaxParkedUploads, c.MaxActiveRelays, c.MaxWaitingRelays, c.MaxClientRequests = 0, 0, 0, 0
+	c.ThemeDir = ""
+	c.P2P = false
+	c.MDNS = false
+	c.ClusterNodes = nil
+	c.Gzip = false
+	if features := enabledFeatures(&c); !slices.Equal(features, []string{"metrics", "websocket"}) {
+		t.Fatal(features)
+	}
+
+	// Every optional feature on.
+	c.AdminToken = "token"
+	c.AccessLog = true
+	c.ShowQR = true
+	c.TaskRateLimit = 1024
+	c.MaxClientRequests = 10
+	c.ThemeDir = "/etc/webfs/theme"
+	c.P2P = true
+	c.MDNS = true
+	c.ClusterNodes = stringList{"http://a:8080", "http://b:8080"}
+	c.Gzip = true
+	if features := enabledFeatures(&c); !slices.Equal(features, []string{
+		"metrics",
+		"websocket",
+		"admin",
+		"access_log",
+		"show_qr",
+		"rate_limit",
+		"limits",
+		"theme",
+		"p2p",
+		"mdns",
+		"cluster",
+		"gzip",
+	}) {
+		t.Fatal(features)
+	}
+}
@@ -0,0 +1,186 @@
+// Package i18n implements message catalogs and language negotiation.
+//
+// A catalog is a JSON object of message IDs to messages, in a file named
+// after its language tag, such as "en.json" or "zh.json". Messages are
+// formatted with fmt.Sprintf if arguments are given.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NameID is the message ID of the name of a language in its own language.
+const NameID = "lang.name"
+
+// Bundle is a set of catalogs.
+type Bundle struct {
+	defaultTag string
+	tags       []string // Sorted.
+	catalogs   map[string]map[string]string
+}
+
+// Load loads the catalogs in dir of fsys.
+// Messages missing in a catalog fall back to the catalog of defaultTag.
+func Load(fsys fs.FS, dir, defaultTag string) (*Bundle, error) {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{defaultTag: defaultTag, catalogs: make(map[string]map[string]string)}
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("i18n: %v: %w", name, err)
+		}
+		tag := strings.ToLower(strings.TrimSuffix(path.Base(name), ".json"))
+		b.catalogs[tag] = catalog
+		b.tags = append(b.tags, tag)
+	}
+	if b.catalogs[defaultTag] == nil {
+		return nil, fmt.Errorf("i18n: no catalog of default language %v", defaultTag)
+	}
+	sort.Strings(b.tags)
+	return b, nil
+}
+
+// Language is a language of a bundle.
+type Language struct {
+	Tag  string
+	Name string // Name of the language in itself.
+}
+
+// Languages returns the languages of b sorted by tag.
+func (b *Bundle) Languages() []Language {
+	languages := make([]Language, 0, len(b.tags))
+	for _, tag := range b.tags {
+		languages = append(languages, Language{tag, b.Printer(tag).T(NameID)})
+	}
+	return languages
+}
+
+// Match returns the supported language best matching the preferred tags
+// in order. A tag matches a catalog of the same tag or of its primary
+// language, "zh-CN" matches "zh" for example. The default language is
+// returned if nothing matches.
+func (b *Bundle) Match(tags ...string) string {
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		for tag != "" {
+			if _, ok := b.catalogs[tag]; ok {
+				return tag
+			}
+			i := strings.LastIndexAny(tag, "-_")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return b.defaultTag
+}
+
+// Negotiate returns the language of r. The value of the cookie named
+// cookie overrides the Accept-Language header.
+func (b *Bundle) Negotiate(r *http.Request, cookie string) string {
+	var tags []string
+	if c, err := r.Cookie(cookie); err == nil {
+		tags = append(tags, c.Value)
+	}
+	tags = append(tags, ParseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+	return b.Match(tags...)
+}
+
+// ParseAcceptLanguage returns the tags of an Accept-Language header value
+// in the order of preference. Tags of quality 0 and "*" are omitted.
+func ParseAcceptLanguage(s string) []string {
+	type tagQ struct {
+		tag string
+		q   float64
+	}
+	var tags []tagQ
+	for _, item := range strings.Split(s, ",") {
+		tag, params, _ := strings.Cut(item, ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(k) != "q" {
+				continue
+			}
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				q = 0
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tagQ{tag, q})
+		}
+	}
+	// Stable, so that tags of the same quality keep their order.
+	slices.SortStableFunc(tags, func(a, b tagQ) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// Printer prints the messages of a language.
+type Printer struct {
+	tag      string
+	catalog  map[string]string
+	fallback map[string]string
+}
+
+// Printer returns the printer of tag, which must be a supported language
+// such as the one returned by Match. The default language is used otherwise.
+func (b *Bundle) Printer(tag string) *Printer {
+	catalog, ok := b.catalogs[tag]
+	if !ok {
+		tag, catalog = b.defaultTag, b.catalogs[b.defaultTag]
+	}
+	return &Printer{tag, catalog, b.catalogs[b.defaultTag]}
+}
+
+// Lang returns the language tag of p.
+func (p *Printer) Lang() string {
+	return p.tag
+}
+
+// T returns the message of id formatted with args.
+// The id itself is returned if the message is not found.
+func (p *Printer) T(id string, args ...any) string {
+	msg, ok := p.catalog[id]
+	if !ok {
+		if msg, ok = p.fallback[id]; !ok {
+			msg = id
+		}
+	}
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
@@ -0,0 +1,96 @@
+package i18n_test
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mkch/webfs/i18n"
+)
+
+var testFS = fstest.MapFS{
+	"locale/en.json": {Data: []byte(`{"lang.name": "English", "hello": "Hello", "files": "%v files"}`)},
+	"locale/zh.json": {Data: []byte(`{"lang.name": "中文", "hello": "你好"}`)},
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		tags   []string
+	}{
+		{"", []string{}},
+		{"zh-CN", []string{"zh-CN"}},
+		{"en;q=0.5, zh-CN, fr;q=0.8", []string{"zh-CN", "fr", "en"}},
+		{"de, *;q=0.1, ja;q=0, en", []string{"de", "en"}},
+		{"en;q=bad, zh", []string{"zh"}},
+	} {
+		if tags := i18n.ParseAcceptLanguage(c.header); !slices.Equal(tags, c.tags) {
+			t.Errorf("%q: %v", c.header, tags)
+		}
+	}
+}
+
+func TestBundle(t *testing.T) {
+	b, err := i18n.Load(testFS, "locale", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if languages := b.Languages(); !slices.Equal(languages, []i18n.Language{{"en", "English"}, {"zh", "中文"}}) {
+		t.Fatal(languages)
+	}
+	for _, c := range []struct {
+		tags []string
+		tag  string
+	}{
+		{nil, "en"},
+		{[]string{"fr"}, "en"},
+		{[]string{"zh"}, "zh"},
+		{[]string{"ZH-Hans-CN"}, "zh"},
+		{[]string{"zh_TW"}, "zh"},
+		{[]string{"fr", "zh-CN", "en"}, "zh"},
+	} {
+		if tag := b.Match(c.tags...); tag != c.tag {
+			t.Errorf("%v: %v", c.tags, tag)
+		}
+	}
+
+	p := b.Printer("zh")
+	if p.Lang() != "zh" || p.T("hello") != "你好" || p.T("files", 2) != "2 files" || p.T("missing") != "missing" {
+		t.Fatal(p.Lang(), p.T("hello"), p.T("files", 2), p.T("missing"))
+	}
+	if p := b.Printer("fr"); p.Lang() != "en" || p.T("hello") != "Hello" {
+		t.Fatal(p.Lang(), p.T("hello"))
+	}
+
+	if _, err := i18n.Load(testFS, "locale", "fr"); err == nil {
+		t.Fatal("no error without default catalog")
+	}
+	if _, err := i18n.Load(fstest.MapFS{"locale/en.json": {Data: []byte(`[]`)}}, "locale", "en"); err == nil {
+		t.Fatal("no error of invalid catalog")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	b, err := i18n.Load(testFS, "locale", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	if tag := b.Negotiate(r, "lang"); tag != "en" {
+		t.Fatal(tag)
+	}
+	r.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	if tag := b.Negotiate(r, "lang"); tag != "zh" {
+		t.Fatal(tag)
+	}
+	r.Header.Set("Cookie", "lang=en")
+	if tag := b.Negotiate(r, "lang"); tag != "en" {
+		t.Fatal(tag)
+	}
+	// Unsupported cookie value falls back to Accept-Language.
+	r.Header.Set("Cookie", "lang=fr")
+	if tag := b.Negotiate(r, "lang"); tag != "zh" {
+		t.Fatal(tag)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// retryAfter is the value of Retry-After header of responses rejected by limits.
+const retryAfter = time.Second * 5
+
+// slots counts the usage of a limited resource.
+type slots struct {
+	n atomic.Int64
+}
+
+// acquire takes a slot if less than max slots are used.
+// If max is not positive, the number of slots is unlimited.
+func (s *slots) acquire(max int) bool {
+	if max <= 0 {
+		s.n.Add(1)
+		return true
+	}
+	for {
+		n := s.n.Load()
+		if n >= int64(max) {
+			return false
+		}
+		if s.n.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release returns a slot taken by acquire.
+func (s *slots) release() {
+	s.n.Add(-1)
+}
+
+var parkedSlots slots  // Slots of parked uploads.
+var relaySlots slots   // Slots of active relays.
+var waitingSlots slots // Slots of receivers waiting for senders.
+
+// errTooManyRelays is the download error of an upload handed to a receiver
+// rejected by max_active_relays. The sender may retry later.
+var errTooManyRelays = errors.New("too many relays")
+
+// clientRequests counts the concurrent requests of each client IP.
+var clientRequests = struct {
+	l sync.Mutex
+	n map[string]int
+}{n: make(map[string]int)}
+
+func acquireClientRequest(ip string, max int) bool {
+	clientRequests.l.Lock()
+	defer clientRequests.l.Unlock()
+	if max > 0 && clientRequests.n[ip] >= max {
+		return false
+	}
+	clientRequests.n[ip]++
+	return true
+}
+
+func releaseClientRequest(ip string) {
+	clientRequests.l.Lock()
+	defer clientRequests.l.Unlock()
+	if clientRequests.n[ip]--; clientRequests.n[ip] <= 0 {
+		delete(clientRequests.n, ip)
+	}
+}
+
+// rejectLimited responds a request rejected by a limit.
+func rejectLimited(w http.ResponseWriter, r *http.Request, code int, id string) {
+	limitedRequests.Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	httpError(w, r, code, id)
+}
+
+// clientLimitHandler limits the concurrent requests of each client IP.
+// Requests of probe endpoints are not limited.
+func clientLimitHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isProbe(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if !acquireClientRequest(ip, getConfig().MaxClientRequests) {
+			requestLogger(r).Warn("too many requests", "client_ip", ip)
+			rejectLimited(w, r, http.StatusTooManyRequests, "error.too_many_requests")
+			return
+		}
+		defer releaseClientRequest(ip)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/mkch/webfs/task"
+)
+
+func TestSlots(t *testing.T) {
+	var s slots
+	if !s.acquire(1) {
+		t.Fatal("should acquire")
+	}
+	if s.acquire(1) {
+		t.Fatal("should not acquire")
+	}
+	if !s.acquire(0) {
+		t.Fatal("unlimited")
+	}
+	s.release()
+	s.release()
+	if !s.acquire(1) {
+		t.Fatal("should acquire")
+	}
+}
+
+func TestClientLimit(t *testing.T) {
+	setConfig(t, func(c *config) { c.MaxClientRequests = 1 })
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	handler := clientLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			close(blocked)
+			<-release
+		}
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+	<-blocked
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/other", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// Probes are not limited.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+
+	// Another client.
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/other", nil)
+	r.RemoteAddr = "192.0.2.2:1234"
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+	close(release)
+}
+
+func TestParkedUploadLimit(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6; c.MaxParkedUploads = 1 })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new_task", handleNewTask)
+	mux.HandleFunc("/send_file", handleSendFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/new_task", "application/json",
+		strings.NewReader(`[{"name":"file1","size":3},{"name":"file2","size":3}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var newTask struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&newTask); err != nil {
+		t.Fatal(err)
+	}
+
+	sendURL := func(index int) string {
+		return fmt.Sprintf("%v/send_file?task=%v&secret=%v&index=%v",
+			server.URL, url.QueryEscape(newTask.ID), url.QueryEscape(newTask.Secret), index)
+	}
+	go http.Post(sendURL(0), "", strings.NewReader("abc"))
+	for parkedUploads.Value() == 0 {
+		runtime.Gosched()
+	}
+
+	resp, err = http.Post(sendURL(1), "", strings.NewReader("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatal(resp.Status)
+	}
+	// Release the parked upload.
+	task.Query(newTask.ID).CtxCancel()
+}
+
+func TestMaxTaskFiles(t *testing.T) {
+	setConfig(t, func(c *config) { c.MaxTaskFiles = 1 })
+	w := httptest.NewRecorder()
+	handleNewTask(w, httptest.NewRequest("POST", "/new_task", strings.NewReader(`[{"name":"a","size":1},{"name":"b","size":1}]`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestRelayLimits(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6; c.MaxActiveRelays = 1; c.MaxWaitingRelays = 1 })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new_task", handleNewTask)
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/new_task", "application/json",
+		strings.NewReader(`[{"name":"file1","size":3},{"name":"file2","size":3}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var newTask struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&newTask); err != nil {
+		t.Fatal(err)
+	}
+	defer task.Query(newTask.ID).CtxCancel()
+
+	sendURL := func(index int) string {
+		return fmt.Sprintf("%v/send_file?task=%v&secret=%v&index=%v",
+			server.URL, url.QueryEscape(newTask.ID), url.QueryEscape(newTask.Secret), index)
+	}
+	receiveURL := func(index int) string {
+		return fmt.Sprintf("%v/r/%v?index=%v", server.URL, url.PathEscape(newTask.ID), index)
+	}
+	receive := func(index int) <-chan *http.Response {
+		ch := make(chan *http.Response, 1)
+		go func() {
+			resp, err := http.Get(receiveURL(index))
+			if err != nil {
+				t.Error(err)
+			}
+			ch <- resp
+		}()
+		return ch
+	}
+
+	// A waiting receiver doesn't hold a relay slot.
+	received := receive(0)
+	for waitingSlots.n.Load() == 0 {
+		runtime.Gosched()
+	}
+	if n := relaySlots.n.Load(); n != 0 {
+		t.Fatal(n)
+	}
+	resp, err = http.Get(receiveURL(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatal(resp.Status)
+	}
+	if resp, err := http.Post(sendURL(0), "", strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.Status)
+	}
+	resp = <-received
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "abc" {
+		t.Fatal(resp.Status, string(body))
+	}
+	resp.Body.Close()
+
+	// Both sides are rejected if no relay slot is left after the handoff.
+	relaySlots.acquire(0)
+	defer relaySlots.release()
+	received = receive(1)
+	for waitingSlots.n.Load() == 0 {
+		runtime.Gosched()
+	}
+	if resp, err := http.Post(sendURL(1), "", strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatal(resp.Status)
+	}
+	if resp = <-received; resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal(resp.Status)
+	}
+	if state := task.Query(newTask.ID).File(1).Status().State; state != task.FileWaiting {
+		t.Fatal(state)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"net/http"
+
+	"github.com/mkch/webfs/i18n"
+)
+
+//go:embed locale
+var localeFiles embed.FS
+
+// defaultLang is the language of clients preferring no supported language.
+const defaultLang = "en"
+
+// langCookie is the name of the cookie overriding Accept-Language.
+const langCookie = "lang"
+
+var messages = func() *i18n.Bundle {
+	b, err := i18n.Load(localeFiles, "locale", defaultLang)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// printer returns the printer of the language of r.
+func printer(r *http.Request) *i18n.Printer {
+	return messages.Printer(messages.Negotiate(r, langCookie))
+}
+
+// page is the data common to all pages, embedded in the data of templates.
+type page struct {
+	*i18n.Printer
+	Languages []i18n.Language
+	Root      string // Path of the home page, the base path followed by "/".
+	Public    bool   // Whether the public pages are served along, false on the admin listener.
+	SiteTitle string
+	Footer    string
+}
+
+func newPage(r *http.Request) page {
+	c := getConfig()
+	p := page{
+		Printer:   printer(r),
+		Languages: messages.Languages(),
+		Root:      c.BasePath + "/",
+		Public:    true,
+		SiteTitle: c.SiteTitle,
+		Footer:    c.Footer,
+	}
+	if p.SiteTitle == "" {
+		p.SiteTitle = p.T("home.title")
+	}
+	return p
+}
+
+// setLangHeaders sets the headers of a response localized by p.
+func setLangHeaders(w http.ResponseWriter, p *i18n.Printer) {
+	w.Header().Set("Content-Language", p.Lang())
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+}
+
+// renderPage renders template name with data, which embeds the page
+// returned by newPage(r).
+func renderPage(w http.ResponseWriter, r *http.Request, name string, data any) {
+	setLangHeaders(w, printer(r))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := getTheme().execute(w, name, data); err != nil {
+		requestLogger(r).Error("failed to render page", "template", name, "error", err)
+	}
+}
+
+// httpError responds the message of id formatted with args in the language
+// of r. Browsers get an error page, other clients get text/plain.
+func httpError(w http.ResponseWriter, r *http.Request, code int, id string, args ...any) {
+	p := newPage(r)
+	msg := p.T(id, args...)
+	setLangHeaders(w, p.Printer)
+	if !acceptsHTML(r) {
+		http.Error(w, msg, code)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	err := getTheme().execute(w, "error.html", &struct {
+		page
+		Message string
+	}{p, msg})
+	if err != nil {
+		requestLogger(r).Debug("failed to render error page", "error", err)
+	}
+}
+
+// taskErrID returns the message ID of err returned by Task.CtxErr.
+func taskErrID(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "error.task_expired"
+	case errors.Is(err, errShuttingDown):
+		return "error.shutting_down"
+	default:
+		return "error.task_cancelled"
+	}
+}
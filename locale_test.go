@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestCatalogs checks that every catalog has the same messages.
+func TestCatalogs(t *testing.T) {
+	names, err := fs.Glob(localeFiles, "locale/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, name := range names {
+		data, err := fs.ReadFile(localeFiles, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			t.Fatal(name, err)
+		}
+		keys := make([]string, 0, len(catalog))
+		for id := range catalog {
+			keys = append(keys, id)
+		}
+		sort.Strings(keys)
+		if ids == nil {
+			ids = keys
+		} else if !slices.Equal(keys, ids) {
+			t.Errorf("messages of %v differ from %v", name, names[0])
+		}
+	}
+	if len(messages.Languages()) < 2 {
+		t.Fatal(messages.Languages())
+	}
+}
+
+func TestLocalizedPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleIndex)
+	mux.HandleFunc("/send", handleSend)
+	mux.HandleFunc("/receive", handleReceive)
+	for _, c := range []struct {
+		path, acceptLanguage, cookie string
+		lang, text                   string
+	}{
+		{"/", "", "", "en", "RECEIVE"},
+		{"/", "zh-CN,zh;q=0.9", "", "zh", "接收"},
+		{"/send", "zh-CN,zh;q=0.9", "lang=en", "en", "Drop or paste files here"},
+		{"/receive", "en", "lang=zh", "zh", "文件码"},
+	} {
+		r := httptest.NewRequest("GET", c.path, nil)
+		r.Header.Set("Accept-Language", c.acceptLanguage)
+		r.Header.Set("Cookie", c.cookie)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		body := w.Body.String()
+		if w.Code != http.StatusOK || w.Header().Get("Content-Language") != c.lang ||
+			!strings.Contains(body, `<html lang="`+c.lang+`">`) || !strings.Contains(body, c.text) {
+			t.Errorf("%v %v: %v %v\n%v", c.path, c.lang, w.Code, w.Header(), body)
+		}
+	}
+}
+
+func TestLocalizedError(t *testing.T) {
+	setConfig(t, func(c *config) { c.TaskFailDelay = 0 })
+
+	r := httptest.NewRequest("GET", "/r/NOSUCH", nil)
+	r.Header.Set("Accept-Language", "zh")
+	w := httptest.NewRecorder()
+	handleReceiveFile(w, r)
+	if w.Code != http.StatusNotFound || w.Body.String() != "任务不存在\n" {
+		t.Fatal(w.Code, w.Body)
+	}
+
+	// Browsers get an error page.
+	r.Header.Set("Accept", "text/html,*/*;q=0.8")
+	w = httptest.NewRecorder()
+	handleReceiveFile(w, r)
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "text/html; charset=utf-8" ||
+		!strings.Contains(w.Body.String(), "任务不存在") || !strings.Contains(w.Body.String(), "返回") {
+		t.Fatal(w.Code, w.Header(), w.Body)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mkch/webfs/token"
+)
+
+// logLevel is the level of the default logger.
+var logLevel slog.LevelVar
+
+// newLogger creates a logger writing to w.
+// format is "text" or "json".
+func newLogger(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+type loggerKey struct{}
+
+// requestLogger returns the logger of request r.
+// The logger has the request ID attribute if r is handled by
+// requestIDHandler.
+func requestLogger(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+const requestIDLen = 12
+
+// requestIDHandler assigns an ID to every request, sets it to the
+// X-Request-Id response header and adds it to the request logger.
+func requestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := token.New(requestIDLen)
+		w.Header().Set("X-Request-Id", id)
+		logger := slog.Default().With("request_id", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger)))
+	})
+}
+
+// accessLogHandler logs every request handled by h if access log is enabled.
+// The query string is not logged, because it may contain task secret,
+// neither are task codes in the path, see redactPath.
+// Requests of probe endpoints are not logged.
+func accessLogHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !getConfig().AccessLog || isProbe(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			requestLogger(r).Info("access",
+				"method", r.Method,
+				"path", redactPath(r.URL.Path),
+				"status", rw.status(),
+				"bytes", rw.bytes,
+				"duration", time.Since(start),
+				"client_ip", clientIP(r))
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// redactPath replaces the task code in the receiving path p, /r/<code> or
+// /qr/<code>.<ext> under any base path, with ***. The code is the
+// credential of receivers.
+func redactPath(p string) string {
+	dir, name := path.Split(p)
+	if name == "" || strings.HasSuffix(dir, "/res/r/") || strings.HasSuffix(dir, "/res/qr/") {
+		return p
+	}
+	switch {
+	case strings.HasSuffix(dir, "/r/"):
+		return dir + "***"
+	case strings.HasSuffix(dir, "/qr/"):
+		return dir + "***" + path.Ext(name)
+	}
+	return p
+}
+
+// statusWriter records the status code and body size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap is used by http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
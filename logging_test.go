@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger, err := newLogger(&b, "json", slog.LevelWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("info")
+	if b.Len() != 0 {
+		t.Fatal(b.String())
+	}
+	logger.Warn("warn", "key", "value")
+	var record map[string]any
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["msg"] != "warn" || record["key"] != "value" {
+		t.Fatal(record)
+	}
+
+	if _, err := newLogger(&b, "xml", slog.LevelInfo); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var b bytes.Buffer
+	logger, err := newLogger(&b, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, func(c *config) { c.AccessLog = true })
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(defaultLogger)
+
+	handler := requestIDHandler(accessLogHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLogger(r).Info("handled")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("abc"))
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/send_file?task=ABC&secret=SECRET", nil))
+	requestID := w.Header().Get("X-Request-Id")
+	if len(requestID) != requestIDLen {
+		t.Fatal(requestID)
+	}
+
+	dec := json.NewDecoder(&b)
+	var handled, access map[string]any
+	if err := dec.Decode(&handled); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&access); err != nil {
+		t.Fatal(err)
+	}
+	if handled["request_id"] != requestID || access["request_id"] != requestID {
+		t.Fatal(handled, access)
+	}
+	if access["path"] != "/send_file" || access["status"] != float64(http.StatusTeapot) ||
+		access["bytes"] != float64(3) || access["method"] != "GET" || access["client_ip"] != "192.0.2.1" {
+		t.Fatal(access)
+	}
+	if bytes.Contains(b.Bytes(), []byte("SECRET")) {
+		t.Fatal(b.String())
+	}
+
+	b.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/r/CODE123", nil))
+	if bytes.Contains(b.Bytes(), []byte("CODE123")) || !bytes.Contains(b.Bytes(), []byte(`"path":"/r/***"`)) {
+		t.Fatal(b.String())
+	}
+}
+
+func TestRedactPath(t *testing.T) {
+	for p, want := range map[string]string{
+		"/r/ABC":               "/r/***",
+		"/webfs/r/ABC":         "/webfs/r/***",
+		"/qr/ABC.png":          "/qr/***.png",
+		"/webfs/qr/ABC.svg":    "/webfs/qr/***.svg",
+		"/r/":                  "/r/",
+		"/send_file":           "/send_file",
+		"/res/r/file_list.css": "/res/r/file_list.css",
+	} {
+		if got := redactPath(p); got != want {
+			t.Fatal(p, got)
+		}
+	}
+}
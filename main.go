@@ -1,22 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/mkch/webfs/modfs"
+	"github.com/mkch/webfs/cluster"
+	"github.com/mkch/webfs/metrics"
 	"github.com/mkch/webfs/task"
 	"github.com/mkch/webfs/token"
 
@@ -26,60 +32,138 @@ import (
 //go:embed static
 var staticFiles embed.FS
 
-var staticFileServer = http.FileServer(http.FS(
-	// Add a valid ModTime to embed.FS, so the response can be cached by client.
-	// The original ModTime of the file returned by embed.FS.Open is 0.
-	&modfs.FS{
-		FS:           staticFiles,
-		LastModified: time.Now(),
-	}))
-
 //go:embed template
 var templateFiles embed.FS
 
-var templates = template.Must(template.ParseFS(templateFiles, "template/*.html"))
-
-const DefaultServeAddr = ":8080"
-
-const DefaultIDLen = 3
-const MaxIDLen = 64
-
-var idLen int   // length of task code.
-var showQR bool // Whether show QR code when sending file.
-
 func main() {
-	var serveAddr string
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "admin":
+			os.Exit(runAdmin(os.Args[2:], os.Stdout, os.Stderr))
+		case "send":
+			os.Exit(runSend(os.Args[2:], os.Stdout, os.Stderr))
+		case "receive":
+			os.Exit(runReceive(os.Args[2:], os.Stdout, os.Stderr))
+		case "discover":
+			os.Exit(runDiscover(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
 
-	flag.StringVar(&serveAddr, "http", DefaultServeAddr, "HTTP service address")
-	flag.IntVar(&idLen, "code-len", DefaultIDLen, fmt.Sprintf("Length of the task code, [%v,%v]", DefaultIDLen, MaxIDLen))
-	flag.BoolVar(&showQR, "show-qr", false, "Show QR code of downloading URL in sending page")
-	flag.Parse()
+	c, err := loadConfig(os.Args[0], os.Args[1:], os.Stderr)
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
 
-	if idLen < DefaultIDLen || idLen > MaxIDLen {
-		fmt.Fprintln(os.Stderr, "Invalid code-len")
+	logger, err := newLogger(os.Stderr, c.LogFormat, &logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	theme, err := loadTheme(c.ThemeDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+	currentTheme.Store(theme)
+	applyConfig(c)
 
 	http.HandleFunc("/", handleIndex)
 	http.HandleFunc("/new_task", handleNewTask)
 	http.HandleFunc("/cancel_task", handleCancelTask)
+	http.HandleFunc("/add_files", handleAddFiles)
 	http.HandleFunc("/send_file", handleSendFile)
+	http.HandleFunc("/send_ws", handleSendWS)
 	http.HandleFunc("/r/", handleReceiveFile)
 	http.HandleFunc("/send", handleSend)
 	http.HandleFunc("/receive", handleReceive)
 	http.HandleFunc("/res/", handleRes)
+	http.HandleFunc("/qr/", handleQR)
+	http.HandleFunc("/sw.js", handleServiceWorker)
+	http.HandleFunc("/share", handleShare)
+	http.HandleFunc("/signal", handleSignal)
+	http.Handle("/metrics", metrics.Handler())
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("/version", handleVersion)
+
+	listeners, adminListener, err := listen(c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	var adminSrv *http.Server
+	if adminListener == nil {
+		http.Handle("/admin/", adminHandler(false))
+	} else {
+		adminSrv = &http.Server{Handler: requestIDHandler(accessLogHandler(adminHandler(true)))}
+	}
+
+	handler := basePathHandler(c.BasePath, http.DefaultServeMux)
+	if len(c.ClusterNodes) > 0 {
+		cl, _ := cluster.New(c.ClusterNodes, c.ClusterSelf) // Validated.
+		// Tasks are created with the IDs owned by this node.
+		task.SetIDFilter(cl.Owns)
+		handler = clusterHandler(cl, c.BasePath, handler)
+		slog.Info("cluster mode", "self", cl.Self(), "nodes", len(c.ClusterNodes))
+	}
+	handler = requestIDHandler(accessLogHandler(clientLimitHandler(handler)))
+
+	srv := &http.Server{Handler: handler}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, idle := context.WithCancel(ctx)
+	defer idle()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig(os.Args[1:])
+		}
+	}()
+
+	serveErr := make(chan error, len(listeners)+1)
+	for _, l := range listeners {
+		slog.Info("starting server", "addr", l.Addr(), "base_path", c.BasePath)
+		go func(l net.Listener) { serveErr <- srv.Serve(l) }(l)
+	}
+	if adminSrv != nil {
+		slog.Info("starting admin server", "addr", adminListener.Addr())
+		go func() { serveErr <- adminSrv.Serve(adminListener) }()
+	}
+	if c.TerminalQR && isTerminal(os.Stdout) {
+		printQR(os.Stdout, serverURL(listeners[0].Addr(), c.BasePath))
+	}
+	if c.MDNS {
+		go advertise(ctx, c, listeners[0])
+	}
+	go watchdog(ctx)
+	go watchIdle(ctx, idle)
+	notify("READY=1")
 
-	log.Printf("Starting server %v", serveAddr)
-	if err := http.ListenAndServe(serveAddr, nil); err != nil {
+	select {
+	case err := <-serveErr:
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
+	case <-ctx.Done():
+		// Restore the default behavior, so that a second signal kills the process.
+		stop()
+		notify("STOPPING=1")
+		if adminSrv != nil {
+			adminSrv.Close()
+		}
+		shutdown(srv, time.Duration(getConfig().DrainTimeout))
 	}
 }
 
-const defaultTaskTimeout = time.Minute * 10
-const maxTaskTimeout = time.Minute * 30
-const taskSecretLen = 16
-
 // handleCancelTask cancels a fileTask.
 func handleCancelTask(w http.ResponseWriter, r *http.Request) {
 	var query = r.URL.Query()
@@ -93,53 +177,136 @@ func handleCancelTask(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	requestLogger(r).Info("cancel task", "task", t.ID())
 	t.CtxCancel()
 }
 
-// handleNewTask generates a new fileTask and responds the ID and secret.
+// handleNewTask generates a new task and responds the ID and secret.
+// The body is the JSON file list of a file task, or the content of a
+// text task if the type query parameter is "text".
 func handleNewTask(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		httpError(w, r, http.StatusServiceUnavailable, "error.shutting_down")
+		return
+	}
+	c := getConfig()
 	var query = r.URL.Query()
-	timeout := defaultTaskTimeout
+	timeout := time.Duration(c.DefaultTaskTimeout)
 	if query.Has("timeout") {
 		if i, err := strconv.Atoi(query.Get("timeout")); err != nil || i <= 0 {
-			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			httpError(w, r, http.StatusBadRequest, "error.invalid_timeout")
 			return
-		} else if d := time.Second * time.Duration(i); d > maxTaskTimeout {
-			http.Error(w, "invalid timeout", http.StatusBadRequest)
+		} else if d := time.Second * time.Duration(i); d > time.Duration(c.MaxTaskTimeout) {
+			httpError(w, r, http.StatusBadRequest, "error.invalid_timeout")
 			return
 		} else {
 			timeout = d
 		}
 	}
 
-	var files []task.FileInfo
-	if err := json.NewDecoder(r.Body).Decode(&files); err != nil {
-		http.Error(w, "invalid body", http.StatusBadRequest)
+	rateLimit, ok := taskRateLimit(r)
+	if !ok {
+		httpError(w, r, http.StatusBadRequest, "error.invalid_rate_limit")
 		return
 	}
 
-	for _, f := range files {
-		if f.Name == "" || f.Size == 0 || (f.Size < 0 && f.Size != -1) {
-			http.Error(w, "invalid body", http.StatusBadRequest)
+	var t *task.Task
+	var err error
+	switch query.Get("type") {
+	case "", "file":
+		files, ok := decodeFiles(w, r, 0)
+		if !ok {
 			return
 		}
+		t, err = task.New(requestLogger(r), c.CodeLen, timeout, token.New(c.TaskSecretLen), files, clientIP(r))
+	case "text":
+		text, ok := readText(w, r)
+		if !ok {
+			return
+		}
+		t, err = task.NewText(requestLogger(r), c.CodeLen, timeout, token.New(c.TaskSecretLen), text, clientIP(r))
+	default:
+		httpError(w, r, http.StatusBadRequest, "error.invalid_type")
+		return
 	}
-
-	t, err := task.New(idLen, timeout, token.New(taskSecretLen), files)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	t.SetRateLimit(rateLimit)
+	_, isText := t.Text()
 
 	err = json.NewEncoder(w).Encode(struct {
-		ID     string `json:"id"`
-		Secret string `json:"secret"`
-		ShowQR bool   `json:"show_qr"`
-	}{ID: t.ID(), Secret: t.Secret(), ShowQR: showQR})
+		ID         string      `json:"id"`
+		Secret     string      `json:"secret"`
+		ShowQR     bool        `json:"show_qr"`
+		RateLimit  int64       `json:"rate_limit,omitempty"`
+		URL        string      `json:"url"`
+		P2P        bool        `json:"p2p"`
+		ICEServers []iceServer `json:"ice_servers"`
+	}{ID: t.ID(), Secret: t.Secret(), ShowQR: c.ShowQR, RateLimit: rateLimit,
+		URL: publicURL(r, "/r/"+t.ID()), P2P: c.P2P && !isText, ICEServers: iceServers()})
 	if err != nil {
-		log.Println(err)
+		t.Logger().Warn("failed to write new task response", "error", err)
+		return
+	}
+}
+
+// decodeFiles decodes the file list in the body of r.
+// existing is the number of files already in the task.
+// If the file list is invalid, an error is responded and ok is false.
+func decodeFiles(w http.ResponseWriter, r *http.Request, existing int) (files []task.FileInfo, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&files); err != nil {
+		httpError(w, r, http.StatusBadRequest, "error.invalid_body")
+		return nil, false
+	}
+
+	if max := getConfig().MaxTaskFiles; max > 0 && existing+len(files) > max {
+		httpError(w, r, http.StatusBadRequest, "error.too_many_files", max)
+		return nil, false
+	}
+
+	for _, f := range files {
+		if f.Name == "" || f.Size == 0 || (f.Size < 0 && f.Size != -1) {
+			httpError(w, r, http.StatusBadRequest, "error.invalid_body")
+			return nil, false
+		}
+	}
+	return files, true
+}
+
+// handleAddFiles adds files to an existing task and responds the index
+// of the first added file.
+func handleAddFiles(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		httpError(w, r, http.StatusServiceUnavailable, "error.shutting_down")
+		return
+	}
+	var query = r.URL.Query()
+	t := task.Query(query.Get("task"))
+	if t == nil || t.Secret() != query.Get("secret") {
+		failedLookups.Inc()
+		// Increase the cost of brute force.
+		time.Sleep(time.Duration(getConfig().TaskFailDelay))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	files, ok := decodeFiles(w, r, t.NFiles())
+	if !ok {
 		return
 	}
+	first, err := t.AddFiles(files)
+	if err == task.ErrTextTask {
+		httpError(w, r, http.StatusBadRequest, "error.text_task")
+		return
+	} else if err != nil {
+		httpError(w, r, http.StatusNotFound, "error.task_done")
+		return
+	}
+	writeJSON(w, r, struct {
+		First int `json:"first"`
+	}{first})
 }
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -147,20 +314,17 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	r.URL.Path = "static/home.html"
-	staticFileServer.ServeHTTP(w, r)
+	renderPage(w, r, "home.html", &struct{ page }{newPage(r)})
 }
 
 // handleSend renders /send page.
 func handleSend(w http.ResponseWriter, r *http.Request) {
-	r.URL.Path = "static/send.html"
-	staticFileServer.ServeHTTP(w, r)
+	renderPage(w, r, "send.html", &struct{ page }{newPage(r)})
 }
 
 // handleReceive renders /receive page.
 func handleReceive(w http.ResponseWriter, r *http.Request) {
-	r.URL.Path = "static/receive.html"
-	staticFileServer.ServeHTTP(w, r)
+	renderPage(w, r, "receive.html", &struct{ page }{newPage(r)})
 }
 
 // handleSendFile uploads a file to the fileTask.
@@ -168,37 +332,85 @@ func handleSendFile(w http.ResponseWriter, r *http.Request) {
 	var query = r.URL.Query()
 	t := task.Query(query.Get("task"))
 	if t == nil || t.Secret() != query.Get("secret") {
+		failedLookups.Inc()
 		// Increase the cost of brute force.
-		time.Sleep(taskFailDelay)
+		time.Sleep(time.Duration(getConfig().TaskFailDelay))
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
 	index, err := strconv.Atoi(query.Get("index"))
 	if err != nil || index < 0 || index > t.NFiles()-1 {
-		http.Error(w, "invalid index", http.StatusBadRequest)
+		httpError(w, r, http.StatusBadRequest, "error.invalid_index")
 		return
 	}
 
 	file := t.File(index)
-	content := task.NewFileContent(r.Body)
-	select {
-	case <-t.CtxDone():
-		http.Error(w, t.CtxErr().Error(), http.StatusBadRequest)
+	size := file.Info().Size
+	// A segment of the file is uploaded if offset is present.
+	segmented := query.Has("offset")
+	var offset int64
+	if segmented {
+		offset, err = strconv.ParseInt(query.Get("offset"), 10, 64)
+		if err != nil || size < 0 || offset < 0 || r.ContentLength <= 0 || offset+r.ContentLength > size {
+			httpError(w, r, http.StatusBadRequest, "error.invalid_segment")
+			return
+		}
+		size = r.ContentLength
+	}
+	var content *task.FileContent
+	switch encoding := strings.ToLower(r.Header.Get("Content-Encoding")); {
+	case encoding == "" || encoding == "identity":
+		content = task.NewFileContent(task.NewSizeReader(r.Body, size))
+	case (encoding == "gzip" || encoding == "x-gzip") && !segmented:
+		// Pre-compressed by the sender, the size is checked when relayed.
+		content = task.NewEncodedFileContent(r.Body, "gzip")
+	default:
+		httpError(w, r, http.StatusUnsupportedMediaType, "error.unsupported_encoding")
+		return
+	}
+
+	if !parkedSlots.acquire(getConfig().MaxParkedUploads) {
+		requestLogger(r).Warn("too many parked uploads", "task", t.ID())
+		rejectLimited(w, r, http.StatusServiceUnavailable, "error.too_many_uploads")
+		return
+	}
+	parkedUploads.Inc()
+	file.Park(clientIP(r))
+	if segmented {
+		ctx, cancel := taskContext(r.Context(), t)
+		err = file.OfferSegment(ctx, offset, size, content)
+		cancel()
+	} else {
+		select {
+		case <-t.CtxDone():
+			err = t.CtxErr()
+		case <-r.Context().Done(): // Upload cancelled by client.
+			err = r.Context().Err()
+		case file.Content() <- content:
+		}
+	}
+	parkedUploads.Dec()
+	parkedSlots.release()
+	if err != nil {
+		file.Unpark()
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
-	case file.Content() <- content:
 	}
 
 	select {
 	case <-content.DownloadStarted():
 		// If the downloading started before task timeout/cancellation,
-		// task timeout/cancellation is ignored.
+		// task timeout/cancellation is ignored, except for shutdown
+		// which cuts the upload.
+		stopCut := cutOnShutdown(w, t)
 		select {
 		case <-content.DownloadDone():
 			err = content.DownloadErr()
 		case <-r.Context().Done(): // Upload cancelled by client.
 			err = r.Context().Err()
 		}
+		stopCut()
 	case <-content.DownloadDone(): // Finish downloading.
 		err = content.DownloadErr()
 	case <-t.CtxDone(): // Task timeout/cancelled.
@@ -207,23 +419,30 @@ func handleSendFile(w http.ResponseWriter, r *http.Request) {
 		err = r.Context().Err()
 	}
 
+	if err == errTooManyRelays {
+		rejectLimited(w, r, http.StatusServiceUnavailable, "error.too_many_downloads")
+		return
+	}
 	if err != nil {
+		requestLogger(r).Debug("upload failed", "task", t.ID(), "index", index, "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 }
 
-// taskFailDelay is the delay after failure of
-// finding a task, for security.
-const taskFailDelay = time.Second * 2
-
 // handleReceiveFile download a file from the fileTask.
 func handleReceiveFile(w http.ResponseWriter, r *http.Request) {
 	t := task.Query(path.Base(r.URL.Path))
 	if t == nil {
+		failedLookups.Inc()
 		// Increase the cost of brute force.
-		time.Sleep(taskFailDelay)
-		http.Error(w, "no such task", http.StatusNotFound)
+		time.Sleep(time.Duration(getConfig().TaskFailDelay))
+		httpError(w, r, http.StatusNotFound, "error.no_such_task")
+		return
+	}
+
+	if text, ok := t.Text(); ok {
+		serveText(w, r, t, text)
 		return
 	}
 
@@ -231,21 +450,8 @@ func handleReceiveFile(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	index := 0
 	if !query.Has("index") {
-		if t.NFiles() > 1 {
-			// Show file list.
-			data := &struct {
-				ID        string
-				Indexes   []int
-				Filenames []string
-			}{ID: t.ID()}
-			for i := 0; i < t.NFiles(); i++ {
-				data.Indexes = append(data.Indexes, i)
-				data.Filenames = append(data.Filenames, t.File(i).Info().Name)
-			}
-			err = templates.ExecuteTemplate(w, "file_list.html", data)
-			if err != nil {
-				log.Panic(err)
-			}
+		if t.NFiles() > 1 || query.Has("status") {
+			serveFileList(w, r, t)
 			return
 		}
 	} else {
@@ -253,52 +459,201 @@ func handleReceiveFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil || index < 0 || index > t.NFiles()-1 {
-		http.Error(w, "invalid index", http.StatusBadRequest)
+		httpError(w, r, http.StatusBadRequest, "error.invalid_index")
+		return
+	}
+
+	if query.Has("preview") {
+		servePreview(w, r, t, index)
+		return
+	}
+	// Browsers get the file list to receive the file peer to peer.
+	if !query.Has("index") && acceptsHTML(r) && p2pAvailable(t) {
+		serveFileList(w, r, t)
+		return
+	}
+
+	// Receivers waiting for the sender are limited apart from the relays,
+	// so that they never block the transfers.
+	if !waitingSlots.acquire(getConfig().MaxWaitingRelays) {
+		requestLogger(r).Warn("too many waiting relays", "task", t.ID())
+		rejectLimited(w, r, http.StatusServiceUnavailable, "error.too_many_downloads")
 		return
 	}
 
 	file := t.File(index)
 	fileInfo := file.Info()
 
+	// The file is uploaded as a whole, or in segments read by a RangeReader.
 	var content *task.FileContent
 	select {
 	case content = <-file.Content():
+		// Range is ignored, the upload is consumed as a whole.
+	case <-file.SegmentParked():
 	case <-t.CtxDone():
-		http.Error(w, t.CtxErr().Error(), http.StatusNotFound)
+		waitingSlots.release()
+		httpError(w, r, http.StatusNotFound, taskErrID(t.CtxErr()))
 		return
 	case <-r.Context().Done():
+		waitingSlots.release()
 		// The request connection is closed.
 		// No need to write any response.
 		return
 	}
+	waitingSlots.release()
+
+	if !relaySlots.acquire(getConfig().MaxActiveRelays) {
+		requestLogger(r).Warn("too many relays", "task", t.ID())
+		if content != nil {
+			// Hand the upload back, the sender is rejected too.
+			file.Unpark()
+			content.SetDownloadDone(errTooManyRelays)
+		}
+		rejectLimited(w, r, http.StatusServiceUnavailable, "error.too_many_downloads")
+		return
+	}
+	defer relaySlots.release()
+
+	var segments *task.RangeReader
+	partial := false // Whether a range of the file is served.
+	rangeStart, rangeEnd := int64(0), fileInfo.Size
+	if content == nil {
+		rangeStart, rangeEnd, err = parseRange(r.Header.Get("Range"), fileInfo.Size)
+		if err == errRangeNotSatisfiable {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%v", fileInfo.Size))
+			httpError(w, r, http.StatusRequestedRangeNotSatisfiable, "error.invalid_range")
+			return
+		}
+		partial = err == nil
+		ctx, cancel := taskContext(r.Context(), t)
+		defer cancel()
+		segments = file.NewRangeReader(ctx, clientIP(r), rangeStart, rangeEnd, segmentWindow)
+		defer segments.Close()
+	}
+
+	// finish marks the transfer done. Transfers of segments are tracked
+	// by the RangeReader, of which ranges are parts.
+	finish := func(err error) {
+		if content != nil {
+			content.SetDownloadDone(err)
+			file.FinishTransfer(err)
+		}
+	}
+	if content != nil {
+		file.StartTransfer(clientIP(r))
+	}
+	activeDownloads.Inc()
+	start := time.Now()
 
 	header := w.Header()
-	if fileInfo.Size >= 0 {
+	var src io.Reader
+	var encoding string
+	if content != nil {
+		content.SetDownloadStarted()
+		src, encoding = content.Reader(), content.Encoding()
+	} else {
+		src = segments
+		header.Set("Accept-Ranges", "bytes")
+	}
+	// Ranges are served as is.
+	inline := !partial && query.Get("disposition") == "inline"
+	gzipOK := !partial && acceptsGzip(r)
+	if encoding == "gzip" && (inline || !gzipOK) {
+		// Decompressed for receivers not accepting gzip, and for sniffing.
+		src, encoding = &gunzipReader{src: src, size: fileInfo.Size}, ""
+	}
+	// Compressing is decided by the sniffed type.
+	compress := encoding == "" && gzipOK && getConfig().Gzip &&
+		(fileInfo.Size < 0 || fileInfo.Size >= gzipMinSize)
+	contentType, disposition := "application/octet-stream", "attachment"
+	if inline || compress {
+		br := bufio.NewReaderSize(src, sniffLen)
+		// Errors are returned again by the following reads.
+		head, _ := br.Peek(sniffLen)
+		sniffed := http.DetectContentType(head)
+		if inline {
+			if typ := inlineType(fileInfo.Name, sniffed); typ != "" {
+				contentType, disposition = typ, "inline"
+				header.Set("Content-Security-Policy", inlineCSP(typ))
+			}
+		}
+		compress = compress && compressible(fileInfo.Name, sniffed)
+		src = br
+	}
+	var zw *gzip.Writer
+	switch {
+	case encoding == "gzip":
+		// Passed through as uploaded.
+		checker := newGzipChecker(src, fileInfo.Size)
+		defer checker.Close()
+		src = checker
+		header.Set("Content-Encoding", "gzip")
+	case compress:
+		// The compressed size is unknown, so no Content-Length.
+		zw, _ = gzip.NewWriterLevel(w, gzip.BestSpeed)
+		header.Set("Content-Encoding", "gzip")
+	case partial:
+		header.Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", rangeStart, rangeEnd-1, fileInfo.Size))
+		header.Set("Content-Length", strconv.FormatInt(rangeEnd-rangeStart, 10))
+	case fileInfo.Size >= 0:
 		header.Set("Content-Length", strconv.FormatInt(fileInfo.Size, 10))
 	}
+	header.Set("Vary", "Accept-Encoding")
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Disposition
-	header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename*=utf-8''%v`, url.PathEscape(fileInfo.Name)))
-	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Content-Disposition", fmt.Sprintf(`%v; filename*=utf-8''%v`, disposition, url.PathEscape(fileInfo.Name)))
+	header.Set("Content-Type", contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	// Ask nginx not to buffer the relayed stream.
+	header.Set("X-Accel-Buffering", "no")
+	if partial {
+		w.WriteHeader(http.StatusPartialContent)
+	}
 
-	content.SetDownloadStarted()
-	_, err = io.Copy(w, content.Reader())
+	reader, releaseLimit := limitRelay(r.Context(), src, t, file, clientIP(r))
+	var dst io.Writer = w
+	if zw != nil {
+		dst = zw
+	}
+	stopCut := cutOnShutdown(w, t)
+	n, err := io.Copy(dst, reader)
+	stopCut()
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	releaseLimit()
+	activeDownloads.Dec()
+	relayedBytes.Add(uint64(n))
+	transferDuration.Observe(time.Since(start).Seconds())
+	logger := requestLogger(r).With("task", t.ID(), "index", index)
 	if err != nil {
 		var netErr net.Error
-		if errors.As(err, &netErr) {
-			log.Println(err)
+		if errors.As(err, &netErr) || errors.Is(err, context.Canceled) {
+			logger.Warn("relay failed", "error", err)
 			err = errors.New("network error occurred")
 		} else {
-			log.Panic(err)
+			if errors.Is(err, task.ErrFileTooLarge) || errors.Is(err, task.ErrFileTooSmall) ||
+				errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) {
+				logger.Warn("relay failed", "error", err)
+			} else {
+				logger.Error("relay failed", "error", err)
+			}
+			finish(err)
+			// Abort the response, so the receiver will not
+			// take the truncated or corrupted file as complete.
+			panic(http.ErrAbortHandler)
 		}
+	} else {
+		logger.Info("file relayed", "bytes", n, "duration", time.Since(start))
 	}
-	content.SetDownloadDone(err)
+	finish(err)
 }
 
 func handleRes(w http.ResponseWriter, r *http.Request) {
 	newPath, err := url.JoinPath("static", r.URL.Path)
 	if err != nil {
-		log.Panic(err)
+		httpError(w, r, http.StatusBadRequest, "error.invalid_path")
+		return
 	}
 	r.URL.Path = newPath
-	staticFileServer.ServeHTTP(w, r)
+	getTheme().static.ServeHTTP(w, r)
 }
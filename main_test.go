@@ -10,12 +10,16 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/mkch/webfs/metrics"
+	"github.com/mkch/webfs/task"
 )
 
 func TestNewTask(t *testing.T) {
 	w := httptest.NewRecorder()
-	idLen = 3
-	handleNewTask(w, httptest.NewRequest("GET", "/new_task", nil))
+	setConfig(t, func(c *config) { c.CodeLen = 3 })
+	handleNewTask(w, httptest.NewRequest("POST", "/new_task", strings.NewReader(`[{"name":"file1","size":3}]`)))
 	resp := w.Result()
 	if code := resp.StatusCode; code != http.StatusOK {
 		t.Fatal(code)
@@ -28,16 +32,56 @@ func TestNewTask(t *testing.T) {
 	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
 		t.Fatal(err)
 	}
-	if len(task.ID) != idLen {
+	if len(task.ID) != getConfig().CodeLen {
 		t.Fatal(task.ID)
 	}
-	if len(task.Secret) != taskSecretLen {
+	if len(task.Secret) != getConfig().TaskSecretLen {
 		t.Fatal(task.Secret)
 	}
 }
 
+func TestAddFiles(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6; c.MaxTaskFiles = 3; c.TaskFailDelay = 0 })
+	w := httptest.NewRecorder()
+	handleNewTask(w, httptest.NewRequest("POST", "/new_task", strings.NewReader(`[{"name":"file1","size":3}]`)))
+	var newTask struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(w.Result().Body).Decode(&newTask); err != nil {
+		t.Fatal(err)
+	}
+	defer task.Query(newTask.ID).CtxCancel()
+
+	addFiles := func(secret, body string) *http.Response {
+		w := httptest.NewRecorder()
+		handleAddFiles(w, httptest.NewRequest("POST", "/add_files?task="+newTask.ID+"&secret="+secret, strings.NewReader(body)))
+		return w.Result()
+	}
+	resp := addFiles(newTask.Secret, `[{"name":"file2","size":4},{"name":"file3","size":-1}]`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.Status)
+	}
+	var result struct {
+		First int `json:"first"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.First != 1 || task.Query(newTask.ID).NFiles() != 3 {
+		t.Fatal(result.First)
+	}
+	// Exceeds max_task_files.
+	if resp := addFiles(newTask.Secret, `[{"name":"file4","size":4}]`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatal(resp.Status)
+	}
+	if resp := addFiles("wrong", `[{"name":"file4","size":4}]`); resp.StatusCode != http.StatusNotFound {
+		t.Fatal(resp.Status)
+	}
+}
+
 func TestSendFile(t *testing.T) {
-	idLen = 6
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
 	mux := http.NewServeMux()
 	mux.HandleFunc("/new_task", handleNewTask)
 	mux.HandleFunc("/send_file", handleSendFile)
@@ -45,7 +89,9 @@ func TestSendFile(t *testing.T) {
 
 	server := httptest.NewServer(mux)
 
-	resp, err := http.Get(fmt.Sprintf("%v/new_task", server.URL))
+	const fileContent = "abc"
+	resp, err := http.Post(fmt.Sprintf("%v/new_task", server.URL), "application/json",
+		strings.NewReader(fmt.Sprintf(`[{"name":"file1","size":%v}]`, len(fileContent))))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,7 +103,6 @@ func TestSendFile(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	const fileContent = "abc"
 	type recv struct {
 		Resp *http.Response
 		Err  error
@@ -68,9 +113,9 @@ func TestSendFile(t *testing.T) {
 		recvChan <- &recv{r, e}
 	}()
 
-	resp, err = http.Post(fmt.Sprintf("%v/send_file?task=%v&secret=%v&filename=%v&size=%v",
+	resp, err = http.Post(fmt.Sprintf("%v/send_file?task=%v&secret=%v&index=0",
 		server.URL,
-		url.QueryEscape(task.ID), url.QueryEscape(task.Secret), url.QueryEscape("file1"), len(fileContent)),
+		url.QueryEscape(task.ID), url.QueryEscape(task.Secret)),
 		"", strings.NewReader(fileContent))
 	if err != nil {
 		t.Fatal(err)
@@ -96,6 +141,9 @@ func TestSendFile(t *testing.T) {
 	if ct := recvResp.Header.Get("Content-Type"); ct != "application/octet-stream" {
 		t.Fatal(ct)
 	}
+	if b := recvResp.Header.Get("X-Accel-Buffering"); b != "no" {
+		t.Fatal(b)
+	}
 	var recvFile []byte
 	recvFile, err = io.ReadAll(recvResp.Body)
 	if err != nil {
@@ -105,3 +153,128 @@ func TestSendFile(t *testing.T) {
 		t.Fatal(recvFile)
 	}
 }
+
+func TestSendFileSizeMismatch(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new_task", handleNewTask)
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, content := range []string{"abc", "abcdefg"} {
+		const declaredSize = 5
+		resp, err := http.Post(fmt.Sprintf("%v/new_task", server.URL), "application/json",
+			strings.NewReader(fmt.Sprintf(`[{"name":"file1","size":%v}]`, declaredSize)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var task struct {
+			ID     string `json:"id"`
+			Secret string `json:"secret"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+			t.Fatal(err)
+		}
+
+		recvErr := make(chan error)
+		go func() {
+			r, err := http.Get(fmt.Sprintf("%v/r/%v", server.URL, url.PathEscape(task.ID)))
+			if err == nil {
+				_, err = io.ReadAll(r.Body)
+				r.Body.Close()
+			}
+			recvErr <- err
+		}()
+
+		resp, err = http.Post(fmt.Sprintf("%v/send_file?task=%v&secret=%v&index=0",
+			server.URL,
+			url.QueryEscape(task.ID), url.QueryEscape(task.Secret)),
+			"", strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatal(content, resp.StatusCode, resp.Status)
+		}
+		msg, _ := io.ReadAll(resp.Body)
+		if !strings.Contains(string(msg), "declared size") {
+			t.Fatal(content, string(msg))
+		}
+		if err := <-recvErr; err == nil {
+			t.Fatal(content, "receiver should fail")
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if code := w.Code; code != http.StatusOK {
+		t.Fatal(code)
+	}
+	body := w.Body.String()
+	for _, name := range []string{
+		"webfs_tasks_active",
+		"webfs_tasks_created_total",
+		"webfs_tasks_rejected_total",
+		"webfs_uploads_parked",
+		"webfs_downloads_active",
+		"webfs_relayed_bytes_total",
+		"webfs_transfer_duration_seconds_bucket",
+		"webfs_code_lookup_failures_total",
+	} {
+		if !strings.Contains(body, "\n"+name) {
+			t.Fatal(name)
+		}
+	}
+}
+
+func TestSendFileRateLimit(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new_task", handleNewTask)
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	const size = 64 * 1024
+	const rate = size * 2
+	resp, err := http.Post(fmt.Sprintf("%v/new_task?rate_limit=%v", server.URL, rate), "application/json",
+		strings.NewReader(fmt.Sprintf(`[{"name":"file1","size":%v}]`, size)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var task struct {
+		ID        string `json:"id"`
+		Secret    string `json:"secret"`
+		RateLimit int64  `json:"rate_limit"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		t.Fatal(err)
+	}
+	if task.RateLimit != rate {
+		t.Fatal(task.RateLimit)
+	}
+
+	go http.Post(fmt.Sprintf("%v/send_file?task=%v&secret=%v&index=0",
+		server.URL, url.QueryEscape(task.ID), url.QueryEscape(task.Secret)),
+		"", strings.NewReader(strings.Repeat("a", size)))
+
+	start := time.Now()
+	resp, err = http.Get(fmt.Sprintf("%v/r/%v", server.URL, url.PathEscape(task.ID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(io.Discard, resp.Body); err != nil || n != size {
+		t.Fatal(n, err)
+	}
+	// Half a second minus the burst.
+	if d := time.Since(start); d < time.Millisecond*350 {
+		t.Fatal(d)
+	}
+}
@@ -0,0 +1,430 @@
+// Package mdns advertises and discovers services on the local network with
+// multicast DNS (RFC 6762) and DNS-based service discovery (RFC 6763).
+//
+// Only IPv4 multicast is used, and only the records needed by DNS-SD are
+// supported: PTR, SRV, TXT and A. Queries from ports other than 5353 are
+// answered by unicast, so clients don't need to bind the mDNS port.
+package mdns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Port is the UDP port of mDNS.
+const Port = 5353
+
+// GroupAddr is the IPv4 multicast address of mDNS.
+var GroupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: Port}
+
+// TTLs of records in seconds, as recommended by RFC 6762.
+const (
+	hostTTL    = 120
+	serviceTTL = 4500
+	// legacyTTL is the max TTL of responses to legacy unicast queries.
+	legacyTTL = 10
+)
+
+// queryInterval is the interval of queries sent by Query.
+const queryInterval = time.Second
+
+// servicesName is the name enumerating the service types.
+var servicesName = name{"_services", "_dns-sd", "_udp", "local"}
+
+// Service is an instance of a service.
+type Service struct {
+	Instance string   // Instance name, e.g. "webfs on laptop".
+	Type     string   // Service type, e.g. "_webfs._tcp".
+	Host     string   // Host name without ".local".
+	Port     int      // Port of the service.
+	IPs      []net.IP // IPv4 addresses of the host.
+	TXT      []string // TXT record of "key=value" strings.
+}
+
+// TXTValue returns the value of key in the TXT record, "" if not found.
+func (s *Service) TXTValue(key string) string {
+	for _, kv := range s.TXT {
+		if k, v, _ := strings.Cut(kv, "="); strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *Service) typeName() name {
+	return parseName(s.Type + ".local")
+}
+
+func (s *Service) instanceName() name {
+	return append(name{s.Instance}, s.typeName()...)
+}
+
+func (s *Service) hostName() name {
+	return name{s.Host, "local"}
+}
+
+// HostName returns the host name of this host for Service.Host.
+func HostName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "webfs"
+	}
+	// Use the first label of a fully qualified name.
+	host, _, _ = strings.Cut(host, ".")
+	return host
+}
+
+// LocalIPs returns the IPv4 addresses of the up and multicast capable
+// network interfaces, except loopback ones.
+func LocalIPs() []net.IP {
+	var ips []net.IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				if ip := ipNet.IP.To4(); ip != nil {
+					ips = append(ips, ip)
+				}
+			}
+		}
+	}
+	return ips
+}
+
+// Responder answers queries of services.
+type Responder struct {
+	services []Service
+}
+
+// NewResponder creates a Responder of services.
+func NewResponder(services ...Service) *Responder {
+	return &Responder{services: services}
+}
+
+// records returns the records of s. ttl is the TTL of service records.
+func (s *Service) records(ttl uint32) (ptr, srv, txt record, a []record) {
+	hostTTL := min(ttl, hostTTL)
+	ptr = record{name: s.typeName(), typ: typePTR, class: classIN, ttl: ttl, target: s.instanceName()}
+	srv = record{name: s.instanceName(), typ: typeSRV, class: classIN | classTopBit, ttl: hostTTL, port: uint16(s.Port), target: s.hostName()}
+	txt = record{name: s.instanceName(), typ: typeTXT, class: classIN | classTopBit, ttl: ttl, txt: s.TXT}
+	for _, ip := range s.IPs {
+		if ip.To4() != nil {
+			a = append(a, record{name: s.hostName(), typ: typeA, class: classIN | classTopBit, ttl: hostTTL, ip: ip})
+		}
+	}
+	return
+}
+
+// answer returns the response of query, or nil if nothing is known.
+// legacy is true if the query is a legacy unicast query.
+func (r *Responder) answer(query *message, legacy bool) *message {
+	resp := &message{flags: flagResponse | flagAuthority}
+	seen := make(map[string]bool)
+	add := func(list *[]record, recs ...record) {
+		for _, rec := range recs {
+			key := string(appendRecord(nil, &rec))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			*list = append(*list, rec)
+		}
+	}
+	for _, q := range query.questions {
+		any := q.typ == typeANY
+		for i := range r.services {
+			s := &r.services[i]
+			ptr, srv, txt, a := s.records(serviceTTL)
+			switch {
+			case q.name.equal(servicesName) && (any || q.typ == typePTR):
+				add(&resp.answers, record{name: servicesName, typ: typePTR, class: classIN, ttl: serviceTTL, target: s.typeName()})
+			case q.name.equal(s.typeName()) && (any || q.typ == typePTR):
+				add(&resp.answers, ptr)
+				add(&resp.additionals, srv, txt)
+				add(&resp.additionals, a...)
+			case q.name.equal(s.instanceName()):
+				if any || q.typ == typeSRV {
+					add(&resp.answers, srv)
+				}
+				if any || q.typ == typeTXT {
+					add(&resp.answers, txt)
+				}
+				add(&resp.additionals, a...)
+			case q.name.equal(s.hostName()) && (any || q.typ == typeA):
+				add(&resp.answers, a...)
+			}
+		}
+	}
+	if len(resp.answers) == 0 {
+		return nil
+	}
+	if legacy {
+		// RFC 6762 6.7: echo the ID and the questions, without cache-flush bits.
+		resp.id = query.id
+		resp.questions = query.questions
+		for _, list := range [][]record{resp.answers, resp.additionals} {
+			for i := range list {
+				list[i].class &^= classTopBit
+				list[i].ttl = min(list[i].ttl, legacyTTL)
+			}
+		}
+	}
+	return resp
+}
+
+// announcement returns the unsolicited response announcing all services.
+// A ttl of 0 announces the services are gone.
+func (r *Responder) announcement(goodbye bool) *message {
+	m := &message{flags: flagResponse | flagAuthority}
+	for i := range r.services {
+		var ttl uint32 = serviceTTL
+		if goodbye {
+			ttl = 0
+		}
+		ptr, srv, txt, a := r.services[i].records(ttl)
+		m.answers = append(m.answers, ptr, srv, txt)
+		m.answers = append(m.answers, a...)
+	}
+	return m
+}
+
+// Serve answers the queries received from conn until reading conn fails.
+// Queries from the mDNS port are answered to GroupAddr, others are
+// answered to the sender.
+func (r *Responder) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 9000)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		query, err := parseMessage(buf[:n])
+		if err != nil || query.flags&flagResponse != 0 {
+			continue
+		}
+		udpAddr, _ := addr.(*net.UDPAddr)
+		legacy := udpAddr == nil || udpAddr.Port != Port
+		resp := r.answer(query, legacy)
+		if resp == nil {
+			continue
+		}
+		var dst net.Addr = GroupAddr
+		if legacy || unicastRequested(query) {
+			dst = addr
+		}
+		conn.WriteTo(resp.pack(), dst)
+	}
+}
+
+// unicastRequested reports whether all questions of query ask for
+// unicast responses.
+func unicastRequested(query *message) bool {
+	for _, q := range query.questions {
+		if q.class&classTopBit == 0 {
+			return false
+		}
+	}
+	return len(query.questions) > 0
+}
+
+// Advertise announces services on the local network and answers queries
+// until ctx is done. Then it announces the services are gone.
+func Advertise(ctx context.Context, services ...Service) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, GroupAddr)
+	if err != nil {
+		return err
+	}
+	r := NewResponder(services...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// RFC 6762 8.3: announce at least twice, one second apart.
+		for i := 0; i < 2; i++ {
+			conn.WriteTo(r.announcement(false).pack(), GroupAddr)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		<-ctx.Done()
+	}()
+	go func() {
+		<-done
+		conn.WriteTo(r.announcement(true).pack(), GroupAddr)
+		conn.Close()
+	}()
+	err = r.Serve(conn)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Browse discovers the instances of serviceType, e.g. "_webfs._tcp", on
+// the local network until ctx is done.
+func Browse(ctx context.Context, serviceType string) ([]Service, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return Query(ctx, conn, GroupAddr, serviceType)
+}
+
+// Query sends queries of serviceType from conn to addr, and collects the
+// instances in the responses until ctx is done. Instances without a SRV
+// record are ignored. Instances without A records get the address of the
+// responder.
+func Query(ctx context.Context, conn net.PacketConn, addr net.Addr, serviceType string) ([]Service, error) {
+	typeName := parseName(serviceType + ".local")
+	var id [2]byte
+	rand.Read(id[:])
+	query := (&message{
+		id:        binary.BigEndian.Uint16(id[:]),
+		questions: []question{{name: typeName, typ: typePTR, class: classIN}},
+	}).pack()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(queryInterval)
+		defer ticker.Stop()
+		for {
+			conn.WriteTo(query, addr)
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				// Interrupt the reading.
+				conn.SetReadDeadline(time.Now())
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	c := newCollector(typeName)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		m, err := parseMessage(buf[:n])
+		if err != nil || m.flags&flagResponse == 0 {
+			continue
+		}
+		var fromIP net.IP
+		if udpAddr, ok := from.(*net.UDPAddr); ok {
+			fromIP = udpAddr.IP
+		}
+		c.add(m, fromIP)
+	}
+	return c.services(serviceType), nil
+}
+
+type srvData struct {
+	host name
+	port uint16
+}
+
+// collector collects the records of instances of a service type.
+type collector struct {
+	typeName  name
+	instances map[string]name // Instance names by key.
+	srv       map[string]srvData
+	txt       map[string][]string
+	ips       map[string][]net.IP // By host key.
+	from      map[string]net.IP   // Address of the responder by instance key.
+}
+
+func newCollector(typeName name) *collector {
+	return &collector{
+		typeName:  typeName,
+		instances: make(map[string]name),
+		srv:       make(map[string]srvData),
+		txt:       make(map[string][]string),
+		ips:       make(map[string][]net.IP),
+		from:      make(map[string]net.IP),
+	}
+}
+
+func (c *collector) add(m *message, from net.IP) {
+	for _, r := range append(m.answers, m.additionals...) {
+		key := r.name.key()
+		switch r.typ {
+		case typePTR:
+			if r.name.equal(c.typeName) && len(r.target) > len(c.typeName) && r.ttl > 0 {
+				c.instances[r.target.key()] = r.target
+				c.from[r.target.key()] = from
+			}
+		case typeSRV:
+			c.srv[key] = srvData{r.target, r.port}
+		case typeTXT:
+			c.txt[key] = r.txt
+		case typeA:
+			if !containsIP(c.ips[key], r.ip) {
+				c.ips[key] = append(c.ips[key], r.ip)
+			}
+		}
+	}
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, v := range ips {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *collector) services(serviceType string) []Service {
+	services := []Service{}
+	for key, instance := range c.instances {
+		srv, ok := c.srv[key]
+		if !ok {
+			continue
+		}
+		s := Service{
+			Instance: instance[0],
+			Type:     serviceType,
+			Host:     strings.Join(srv.host, "."),
+			Port:     int(srv.port),
+			IPs:      c.ips[srv.host.key()],
+			TXT:      c.txt[key],
+		}
+		s.Host = strings.TrimSuffix(s.Host, ".local")
+		if len(s.IPs) == 0 && c.from[key] != nil {
+			s.IPs = []net.IP{c.from[key]}
+		}
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Instance < services[j].Instance })
+	return services
+}
@@ -0,0 +1,61 @@
+package mdns_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/mdns"
+)
+
+func listenLoopback(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestQuery(t *testing.T) {
+	services := []mdns.Service{
+		{Instance: "webfs on b", Type: "_webfs._tcp", Host: "b", Port: 8080,
+			IPs: []net.IP{net.IPv4(192, 168, 1, 2).To4()}, TXT: []string{"version=1.0", "tls=false"}},
+		{Instance: "webfs on a", Type: "_webfs._tcp", Host: "a", Port: 80},
+		{Instance: "web on a", Type: "_http._tcp", Host: "a", Port: 80},
+	}
+	server := listenLoopback(t)
+	go mdns.NewResponder(services...).Serve(server)
+
+	client := listenLoopback(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	found, err := mdns.Query(ctx, client, server.LocalAddr(), "_webfs._tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mdns.Service{services[1], services[0]}
+	// Without A records, the address of the responder is used.
+	want[0].IPs = []net.IP{net.IPv4(127, 0, 0, 1).To4()}
+	if len(found) == 2 && len(found[0].IPs) == 1 {
+		found[0].IPs[0] = found[0].IPs[0].To4()
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Fatalf("%+v", found)
+	}
+	if v := found[1].TXTValue("Version"); v != "1.0" {
+		t.Fatal(v)
+	}
+	if v := found[1].TXTValue("path"); v != "" {
+		t.Fatal(v)
+	}
+
+	// Nothing is found of an unknown type.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if found, err := mdns.Query(ctx, client, server.LocalAddr(), "_ftp._tcp"); err != nil || len(found) != 0 {
+		t.Fatal(found, err)
+	}
+}
@@ -0,0 +1,316 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Record types.
+const (
+	typeA    = 1
+	typePTR  = 12
+	typeTXT  = 16
+	typeAAAA = 28
+	typeSRV  = 33
+	typeANY  = 255
+)
+
+const (
+	classIN = 1
+	// classTopBit is the cache-flush bit of records, or the unicast-response
+	// bit of questions.
+	classTopBit = 0x8000
+
+	flagResponse  = 0x8000
+	flagAuthority = 0x0400
+)
+
+var errInvalidMessage = errors.New("mdns: invalid message")
+
+// name is a domain name of labels without the root.
+type name []string
+
+// parseName parses a dot separated name. Labels can't contain dots.
+func parseName(s string) name {
+	return strings.Split(strings.TrimSuffix(s, "."), ".")
+}
+
+// equal reports whether n and o are the same name, case insensitively.
+func (n name) equal(o name) bool {
+	if len(n) != len(o) {
+		return false
+	}
+	for i := range n {
+		if !strings.EqualFold(n[i], o[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns a string identifying n, case insensitively.
+func (n name) key() string {
+	return strings.ToLower(strings.Join(n, "\x00"))
+}
+
+type question struct {
+	name  name
+	typ   uint16
+	class uint16
+}
+
+// record is a resource record. Only the fields of its type are used.
+type record struct {
+	name  name
+	typ   uint16
+	class uint16
+	ttl   uint32
+
+	target name     // PTR and SRV.
+	port   uint16   // SRV.
+	txt    []string // TXT.
+	ip     net.IP   // A and AAAA.
+}
+
+type message struct {
+	id          uint16
+	flags       uint16
+	questions   []question
+	answers     []record
+	additionals []record
+}
+
+func appendName(b []byte, n name) []byte {
+	for _, label := range n {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendRecord(b []byte, r *record) []byte {
+	b = appendName(b, r.name)
+	b = binary.BigEndian.AppendUint16(b, r.typ)
+	b = binary.BigEndian.AppendUint16(b, r.class)
+	b = binary.BigEndian.AppendUint32(b, r.ttl)
+	lenAt := len(b)
+	b = append(b, 0, 0)
+	switch r.typ {
+	case typePTR:
+		b = appendName(b, r.target)
+	case typeSRV:
+		b = binary.BigEndian.AppendUint16(b, 0) // Priority.
+		b = binary.BigEndian.AppendUint16(b, 0) // Weight.
+		b = binary.BigEndian.AppendUint16(b, r.port)
+		b = appendName(b, r.target)
+	case typeTXT:
+		if len(r.txt) == 0 {
+			b = append(b, 0)
+		}
+		for _, s := range r.txt {
+			if len(s) > 255 {
+				s = s[:255]
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	case typeA:
+		b = append(b, r.ip.To4()...)
+	case typeAAAA:
+		b = append(b, r.ip.To16()...)
+	}
+	binary.BigEndian.PutUint16(b[lenAt:], uint16(len(b)-lenAt-2))
+	return b
+}
+
+// pack returns m in the wire format. Names are not compressed.
+func (m *message) pack() []byte {
+	b := make([]byte, 0, 512)
+	b = binary.BigEndian.AppendUint16(b, m.id)
+	b = binary.BigEndian.AppendUint16(b, m.flags)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(m.questions)))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(m.answers)))
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(m.additionals)))
+	for _, q := range m.questions {
+		b = appendName(b, q.name)
+		b = binary.BigEndian.AppendUint16(b, q.typ)
+		b = binary.BigEndian.AppendUint16(b, q.class)
+	}
+	for i := range m.answers {
+		b = appendRecord(b, &m.answers[i])
+	}
+	for i := range m.additionals {
+		b = appendRecord(b, &m.additionals[i])
+	}
+	return b
+}
+
+// parser parses a message.
+type parser struct {
+	msg []byte
+	off int
+}
+
+func (p *parser) uint16() (uint16, error) {
+	if p.off+2 > len(p.msg) {
+		return 0, errInvalidMessage
+	}
+	v := binary.BigEndian.Uint16(p.msg[p.off:])
+	p.off += 2
+	return v, nil
+}
+
+func (p *parser) uint32() (uint32, error) {
+	if p.off+4 > len(p.msg) {
+		return 0, errInvalidMessage
+	}
+	v := binary.BigEndian.Uint32(p.msg[p.off:])
+	p.off += 4
+	return v, nil
+}
+
+// name parses a name, which may be compressed.
+func (p *parser) name() (name, error) {
+	var n name
+	off := p.off
+	jumped := false
+	for hops := 0; ; {
+		if off >= len(p.msg) {
+			return nil, errInvalidMessage
+		}
+		l := int(p.msg[off])
+		switch {
+		case l == 0:
+			if !jumped {
+				p.off = off + 1
+			}
+			return n, nil
+		case l&0xc0 == 0xc0:
+			if off+2 > len(p.msg) {
+				return nil, errInvalidMessage
+			}
+			if hops++; hops > 16 {
+				return nil, errInvalidMessage
+			}
+			if !jumped {
+				p.off = off + 2
+				jumped = true
+			}
+			off = int(binary.BigEndian.Uint16(p.msg[off:]) & 0x3fff)
+		case l&0xc0 != 0:
+			return nil, errInvalidMessage
+		default:
+			if off+1+l > len(p.msg) {
+				return nil, errInvalidMessage
+			}
+			n = append(n, string(p.msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+func (p *parser) question() (q question, err error) {
+	if q.name, err = p.name(); err != nil {
+		return
+	}
+	if q.typ, err = p.uint16(); err != nil {
+		return
+	}
+	q.class, err = p.uint16()
+	return
+}
+
+func (p *parser) record() (r record, err error) {
+	if r.name, err = p.name(); err != nil {
+		return
+	}
+	if r.typ, err = p.uint16(); err != nil {
+		return
+	}
+	if r.class, err = p.uint16(); err != nil {
+		return
+	}
+	if r.ttl, err = p.uint32(); err != nil {
+		return
+	}
+	length, err := p.uint16()
+	if err != nil {
+		return
+	}
+	end := p.off + int(length)
+	if end > len(p.msg) {
+		return r, errInvalidMessage
+	}
+	data := p.msg[p.off:end]
+	switch r.typ {
+	case typePTR:
+		r.target, err = p.name()
+	case typeSRV:
+		p.off += 4 // Priority and weight.
+		if r.port, err = p.uint16(); err == nil {
+			r.target, err = p.name()
+		}
+	case typeTXT:
+		for i := 0; i < len(data); {
+			l := int(data[i])
+			if i+1+l > len(data) {
+				return r, errInvalidMessage
+			}
+			if l > 0 {
+				r.txt = append(r.txt, string(data[i+1:i+1+l]))
+			}
+			i += 1 + l
+		}
+	case typeA, typeAAAA:
+		if len(data) == net.IPv4len || len(data) == net.IPv6len {
+			r.ip = net.IP(append([]byte(nil), data...))
+		}
+	}
+	p.off = end
+	return
+}
+
+// parseMessage parses a message in the wire format.
+func parseMessage(b []byte) (*message, error) {
+	p := &parser{msg: b}
+	var m message
+	var counts [4]uint16
+	var err error
+	if m.id, err = p.uint16(); err != nil {
+		return nil, err
+	}
+	if m.flags, err = p.uint16(); err != nil {
+		return nil, err
+	}
+	for i := range counts {
+		if counts[i], err = p.uint16(); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < int(counts[0]); i++ {
+		q, err := p.question()
+		if err != nil {
+			return nil, err
+		}
+		m.questions = append(m.questions, q)
+	}
+	// Authority records are parsed as additional records.
+	for i := 0; i < int(counts[1])+int(counts[2])+int(counts[3]); i++ {
+		r, err := p.record()
+		if err != nil {
+			return nil, err
+		}
+		if i < int(counts[1]) {
+			m.answers = append(m.answers, r)
+		} else {
+			m.additionals = append(m.additionals, r)
+		}
+	}
+	return &m, nil
+}
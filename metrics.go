@@ -0,0 +1,22 @@
+package main
+
+import "github.com/mkch/webfs/metrics"
+
+var (
+	parkedUploads = metrics.NewGauge("webfs_uploads_parked",
+		"Number of uploads waiting for a receiver.")
+	activeDownloads = metrics.NewGauge("webfs_downloads_active",
+		"Number of files being relayed to receivers.")
+	relayedBytes = metrics.NewCounter("webfs_relayed_bytes_total",
+		"Number of bytes relayed from senders to receivers.")
+	transferDuration = metrics.NewHistogram("webfs_transfer_duration_seconds",
+		"Duration of file relays in seconds.", metrics.ExponentialBuckets(0.1, 4, 8))
+	failedLookups = metrics.NewCounter("webfs_code_lookup_failures_total",
+		"Number of requests with unknown task code or wrong secret.")
+	limitedRequests = metrics.NewCounter("webfs_requests_limited_total",
+		"Number of requests rejected by concurrency limits.")
+	p2pSessions = metrics.NewCounter("webfs_p2p_sessions_total",
+		"Number of peer to peer signaling sessions opened by receivers.")
+	forwardedRequests = metrics.NewCounter("webfs_cluster_forwarded_requests_total",
+		"Number of requests forwarded to the nodes owning their tasks.")
+)
@@ -0,0 +1,291 @@
+// Package metrics implements counters, gauges and histograms
+// exposed in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is a named value that can be written in text format.
+type metric interface {
+	name() string
+	help() string
+	typ() string
+	// writeSamples writes the sample lines of the metric.
+	writeSamples(w io.Writer) error
+}
+
+// Registry is a collection of metrics.
+type Registry struct {
+	l       sync.RWMutex
+	metrics []metric
+	names   map[string]struct{}
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]struct{})}
+}
+
+// Default is the registry used by the package level New* functions.
+var Default = NewRegistry()
+
+func (r *Registry) register(m metric) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if _, ok := r.names[m.name()]; ok {
+		panic(fmt.Sprintf("metrics: duplicated metric %q", m.name()))
+	}
+	r.names[m.name()] = struct{}{}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes all metrics in r to w in Prometheus text format,
+// in the order of registration.
+func (r *Registry) WriteTo(w io.Writer) (n int64, err error) {
+	r.l.RLock()
+	metrics := r.metrics
+	r.l.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	for _, m := range metrics {
+		if _, err = fmt.Fprintf(cw, "# HELP %v %v\n# TYPE %v %v\n", m.name(), m.help(), m.name(), m.typ()); err != nil {
+			return cw.n, err
+		}
+		if err = m.writeSamples(cw); err != nil {
+			return cw.n, err
+		}
+	}
+	err = bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics of r.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// Handler returns a http.Handler that serves the metrics of Default.
+func Handler() http.Handler {
+	return Default
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+	return
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// desc is the common part of all metrics.
+type desc struct {
+	n, h string
+}
+
+func (d *desc) name() string {
+	return d.n
+}
+
+func (d *desc) help() string {
+	return d.h
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	desc
+	v atomic.Uint64
+}
+
+// NewCounter creates a Counter and registers it in Default.
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+// NewCounter creates a Counter and registers it in r.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{desc: desc{name, help}}
+	r.register(c)
+	return c
+}
+
+// Inc increases c by 1.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add increases c by n.
+func (c *Counter) Add(n uint64) {
+	c.v.Add(n)
+}
+
+// Value returns the current value of c.
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+func (c *Counter) typ() string {
+	return "counter"
+}
+
+func (c *Counter) writeSamples(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%v %v\n", c.n, c.Value())
+	return err
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	desc
+	v atomic.Int64
+}
+
+// NewGauge creates a Gauge and registers it in Default.
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+// NewGauge creates a Gauge and registers it in r.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{name, help}}
+	r.register(g)
+	return g
+}
+
+// Inc increases g by 1.
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+// Dec decreases g by 1.
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+// Set sets g to v.
+func (g *Gauge) Set(v int64) {
+	g.v.Store(v)
+}
+
+// Value returns the current value of g.
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+func (g *Gauge) typ() string {
+	return "gauge"
+}
+
+func (g *Gauge) writeSamples(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%v %v\n", g.n, g.Value())
+	return err
+}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	desc
+	upperBounds []float64 // Sorted upper bounds of buckets, without +Inf.
+
+	l      sync.Mutex
+	counts []uint64 // Non-cumulative count of each bucket, the last one is +Inf.
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a Histogram and registers it in Default.
+// buckets are the upper bounds of buckets in increasing order,
+// the +Inf bucket is added implicitly.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.NewHistogram(name, help, buckets)
+}
+
+// NewHistogram creates a Histogram and registers it in r.
+// See NewHistogram for buckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			panic("metrics: histogram buckets must be in increasing order")
+		}
+	}
+	h := &Histogram{
+		desc:        desc{name, help},
+		upperBounds: buckets,
+		counts:      make([]uint64, len(buckets)+1),
+	}
+	r.register(h)
+	return h
+}
+
+// ExponentialBuckets returns count buckets, the first upper bound is start,
+// and each following one is factor times the previous.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Observe adds an observation v to h.
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for ; i < len(h.upperBounds); i++ {
+		if v <= h.upperBounds[i] {
+			break
+		}
+	}
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) typ() string {
+	return "histogram"
+}
+
+func (h *Histogram) writeSamples(w io.Writer) error {
+	h.l.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.l.Unlock()
+
+	var cumulative uint64
+	for i, c := range counts {
+		cumulative += c
+		le := math.Inf(1)
+		if i < len(h.upperBounds) {
+			le = h.upperBounds[i]
+		}
+		if _, err := fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", h.n, formatFloat(le), cumulative); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%v_sum %v\n%v_count %v\n", h.n, formatFloat(sum), h.n, count)
+	return err
+}
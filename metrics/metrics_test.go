@@ -0,0 +1,74 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mkch/webfs/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := r.NewCounter("test_counter_total", "A counter.")
+	g := r.NewGauge("test_gauge", "A gauge.")
+	h := r.NewHistogram("test_duration_seconds", "A histogram.", []float64{1, 2})
+
+	c.Inc()
+	c.Add(2)
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h.Observe(0.5)
+	h.Observe(1.5)
+	h.Observe(3)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	const expected = `# HELP test_counter_total A counter.
+# TYPE test_counter_total counter
+test_counter_total 3
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 1
+# HELP test_duration_seconds A histogram.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="1"} 1
+test_duration_seconds_bucket{le="2"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 5
+test_duration_seconds_count 3
+`
+	if str := b.String(); str != expected {
+		t.Fatal(str)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatal(ct)
+	}
+	if body := w.Body.String(); body != expected {
+		t.Fatal(body)
+	}
+}
+
+func TestDuplicatedName(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounter("dup", "")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("should panic")
+		}
+	}()
+	r.NewGauge("dup", "")
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	b := metrics.ExponentialBuckets(1, 2, 4)
+	if len(b) != 4 || b[0] != 1 || b[1] != 2 || b[2] != 4 || b[3] != 8 {
+		t.Fatal(b)
+	}
+}
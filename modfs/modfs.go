@@ -10,6 +10,9 @@ import (
 type FS struct {
 	fs.FS
 	LastModified time.Time // ModTime of all files will be this value.
+	// If KeepNewer is true, files modified after LastModified keep their
+	// own ModTime, so ModTime is never earlier than LastModified.
+	KeepNewer bool
 }
 
 func (fs *FS) Open(name string) (fs.File, error) {
@@ -17,12 +20,13 @@ func (fs *FS) Open(name string) (fs.File, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &file{f, fs.LastModified}, nil
+	return &file{f, fs.LastModified, fs.KeepNewer}, nil
 }
 
 type file struct {
 	fs.File
 	lastModified time.Time
+	keepNewer    bool
 }
 
 func (f *file) Stat() (fs.FileInfo, error) {
@@ -30,7 +34,7 @@ func (f *file) Stat() (fs.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &fileInfo{info, f.lastModified}, nil
+	return &fileInfo{info, f.lastModified, f.keepNewer}, nil
 }
 
 func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
@@ -38,7 +42,7 @@ func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
 		entries, err := dirFile.ReadDir(n)
 		if err == nil {
 			for i, dir := range entries {
-				entries[i] = &dirEntry{dir, f.lastModified}
+				entries[i] = &dirEntry{dir, f.lastModified, f.keepNewer}
 			}
 		}
 		return entries, err
@@ -49,21 +53,26 @@ func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
 type dirEntry struct {
 	fs.DirEntry
 	lastModified time.Time
+	keepNewer    bool
 }
 
 func (dir *dirEntry) Info() (fs.FileInfo, error) {
 	if info, err := dir.DirEntry.Info(); err != nil {
 		return info, err
 	} else {
-		return &fileInfo{info, dir.lastModified}, nil
+		return &fileInfo{info, dir.lastModified, dir.keepNewer}, nil
 	}
 }
 
 type fileInfo struct {
 	fs.FileInfo
 	lastModified time.Time
+	keepNewer    bool
 }
 
 func (info *fileInfo) ModTime() time.Time {
+	if modTime := info.FileInfo.ModTime(); info.keepNewer && modTime.After(info.lastModified) {
+		return modTime
+	}
 	return info.lastModified
 }
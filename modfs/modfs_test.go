@@ -137,3 +137,21 @@ func Test_FS_ReadDir(t *testing.T) {
 		t.Fatalf("%v expected, but got %v", newTime, modTime)
 	}
 }
+
+func Test_FS_KeepNewer(t *testing.T) {
+	for _, test := range []struct {
+		lastModified, expected time.Time
+	}{
+		{time.Unix(10, 20), time.Unix(10, 20)},
+		{time.Unix(0, 1), time.Unix(1, 2)}, // file1 is newer.
+	} {
+		var modfs = modfs.FS{FS: fakeFS{}, LastModified: test.lastModified, KeepNewer: true}
+		if f, err := modfs.Open("file1"); err != nil {
+			t.Fatal(err)
+		} else if fi, err := f.Stat(); err != nil {
+			t.Fatal(err)
+		} else if modTime := fi.ModTime(); modTime != test.expected {
+			t.Fatalf("%v expected, but got %v", test.expected, modTime)
+		}
+	}
+}
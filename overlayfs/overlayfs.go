@@ -0,0 +1,94 @@
+// Package overlayfs implements a file system overlaying another one.
+package overlayfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// FS is a fs.FS whose files are the ones in Upper, or in Lower if not
+// found in Upper. The entries of directories in both are merged.
+type FS struct {
+	Upper fs.FS
+	Lower fs.FS
+}
+
+func (o *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := o.Upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.Lower.Open(name)
+	} else if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.IsDir() {
+		return f, nil
+	}
+	return &dir{File: f, fs: o, name: name}, nil
+}
+
+// ReadDir reads the merged entries of directory name sorted by filename.
+func (o *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, err := fs.ReadDir(o.Upper, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fs.ReadDir(o.Lower, name)
+	} else if err != nil {
+		return nil, err
+	}
+	lower, err := fs.ReadDir(o.Lower, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	entries := make(map[string]fs.DirEntry, len(upper)+len(lower))
+	for _, entry := range lower {
+		entries[entry.Name()] = entry
+	}
+	for _, entry := range upper {
+		entries[entry.Name()] = entry
+	}
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result, nil
+}
+
+// dir is a directory of Upper, whose entries are merged with Lower.
+type dir struct {
+	fs.File
+	fs      *FS
+	name    string
+	entries []fs.DirEntry // Entries not read yet, nil before the first read.
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = d.entries[len(d.entries):]
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,126 @@
+package overlayfs_test
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mkch/webfs/overlayfs"
+)
+
+var (
+	upperTime = time.Unix(100, 0)
+	lowerTime = time.Unix(1, 0)
+)
+
+func newFS() *overlayfs.FS {
+	return &overlayfs.FS{
+		Upper: fstest.MapFS{
+			"static/a.css":     {Data: []byte("upper a"), ModTime: upperTime},
+			"static/c.css":     {Data: []byte("upper c"), ModTime: upperTime},
+			"template/x.html":  {Data: []byte("upper x"), ModTime: upperTime},
+			"static/sub/e.txt": {Data: []byte("upper e"), ModTime: upperTime},
+		},
+		Lower: fstest.MapFS{
+			"static/a.css":    {Data: []byte("lower a"), ModTime: lowerTime},
+			"static/b.css":    {Data: []byte("lower b"), ModTime: lowerTime},
+			"template/y.html": {Data: []byte("lower y"), ModTime: lowerTime},
+			"other/d.txt":     {Data: []byte("lower d"), ModTime: lowerTime},
+		},
+	}
+}
+
+func TestOpen(t *testing.T) {
+	fsys := newFS()
+	for name, want := range map[string]struct {
+		data    string
+		modTime time.Time
+	}{
+		"static/a.css":     {"upper a", upperTime},
+		"static/b.css":     {"lower b", lowerTime},
+		"static/c.css":     {"upper c", upperTime},
+		"other/d.txt":      {"lower d", lowerTime},
+		"static/sub/e.txt": {"upper e", upperTime},
+	} {
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if string(data) != want.data || !info.ModTime().Equal(want.modTime) {
+			t.Errorf("%v: %q %v", name, data, info.ModTime())
+		}
+	}
+	for _, name := range []string{"static/none", "../static/a.css", "/static/a.css"} {
+		if _, err := fsys.Open(name); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	fsys := newFS()
+	for dir, want := range map[string][]string{
+		".":        {"other", "static", "template"},
+		"static":   {"a.css", "b.css", "c.css", "sub"},
+		"template": {"x.html", "y.html"},
+		"other":    {"d.txt"},
+	} {
+		entries, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			t.Fatal(dir, err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if len(names) != len(want) {
+			t.Fatal(dir, names)
+		}
+		for i := range names {
+			if names[i] != want[i] {
+				t.Fatal(dir, names)
+			}
+		}
+	}
+
+	// Read a merged directory in parts.
+	f, err := fsys.Open("static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var n int
+	for {
+		entries, err := f.(fs.ReadDirFile).ReadDir(3)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n += len(entries)
+	}
+	if n != 4 {
+		t.Fatal(n)
+	}
+
+	if matches, err := fs.Glob(fsys, "template/*.html"); err != nil || len(matches) != 2 {
+		t.Fatal(matches, err)
+	}
+}
+
+func TestFSTest(t *testing.T) {
+	if err := fstest.TestFS(newFS(), "static/a.css", "static/b.css", "static/c.css", "static/sub/e.txt", "template/x.html", "template/y.html", "other/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
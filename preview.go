@@ -0,0 +1,124 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/mkch/webfs/task"
+)
+
+// inlineKinds maps the content types that can be opened inline to the
+// kinds of preview. Types that may run scripts, such as HTML and SVG,
+// are never opened inline.
+var inlineKinds = map[string]string{
+	"image/png":       "image",
+	"image/jpeg":      "image",
+	"image/gif":       "image",
+	"image/webp":      "image",
+	"image/bmp":       "image",
+	"audio/mpeg":      "audio",
+	"audio/mp4":       "audio",
+	"audio/aac":       "audio",
+	"audio/flac":      "audio",
+	"audio/ogg":       "audio",
+	"audio/wav":       "audio",
+	"audio/wave":      "audio",
+	"video/mp4":       "video",
+	"video/webm":      "video",
+	"video/ogg":       "video",
+	"application/ogg": "video",
+	"application/pdf": "pdf",
+}
+
+// extTypes are the content types of file extensions which are not
+// in the builtin table of package mime.
+var extTypes = map[string]string{
+	".bmp":  "image/bmp",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".flac": "audio/flac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+}
+
+// mediaType returns the content type without parameters.
+func mediaType(contentType string) string {
+	t, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// nameType returns the content type of a file by its name, "" if unknown.
+func nameType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := extTypes[ext]; ok {
+		return t
+	}
+	return mediaType(mime.TypeByExtension(ext))
+}
+
+// previewKind returns the kind of preview of a file by its name:
+// "image", "audio", "video", "pdf", or "" if the file can't be previewed.
+func previewKind(name string) string {
+	return inlineKinds[nameType(name)]
+}
+
+// sniffLen is the number of bytes used by http.DetectContentType.
+const sniffLen = 512
+
+// inlineType returns the content type to open a file inline, or "" if the
+// file must be downloaded as an attachment. sniffed is the type detected
+// from the first bytes of the file by http.DetectContentType.
+func inlineType(name, sniffed string) string {
+	sniffed = mediaType(sniffed)
+	if _, ok := inlineKinds[sniffed]; ok {
+		return sniffed
+	}
+	// Many audio and video formats can't be sniffed, trust the name then.
+	if kind := previewKind(name); sniffed == "application/octet-stream" && (kind == "audio" || kind == "video") {
+		return nameType(name)
+	}
+	return ""
+}
+
+// inlineCSP returns the Content-Security-Policy of files of content type
+// typ opened inline. The file is sandboxed, so that it can't run scripts
+// even if it is sniffed as another type by the browser. PDF files are not
+// sandboxed, because the PDF viewer of Chromium refuses to render in a
+// sandboxed document. They are only sniffed from the "%PDF-" signature,
+// and scripts in them are run by the viewer, not the page.
+func inlineCSP(typ string) string {
+	const csp = "default-src 'none'; img-src 'self'; media-src 'self'; object-src 'self'; style-src 'unsafe-inline'"
+	if typ == "application/pdf" {
+		return csp
+	}
+	return "sandbox; " + csp
+}
+
+// acceptsHTML reports whether the client of r is a browser.
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// servePreview renders the preview page of the file at index of task t.
+// The page only links to the file, because opening it consumes the upload
+// parked by the sender: the receiver chooses to download it or open it inline.
+func servePreview(w http.ResponseWriter, r *http.Request, t *task.Task, index int) {
+	info := t.File(index).Info()
+	renderPage(w, r, "preview.html", &struct {
+		page
+		ID    string
+		Index int
+		Name  string
+		Size  int64
+		Kind  string
+	}{newPage(r), t.ID(), index, info.Name, info.Size, previewKind(info.Name)})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+func TestInlineType(t *testing.T) {
+	const png = "\x89PNG\x0D\x0A\x1A\x0A"
+	for _, c := range []struct {
+		name    string
+		content string
+		typ     string
+	}{
+		{"a.png", png, "image/png"},
+		{"a.jpg", png, "image/png"}, // The sniffed type wins.
+		{"a.png", "<html><script>alert(1)</script>", ""},
+		{"a.svg", `<svg xmlns="http://www.w3.org/2000/svg"></svg>`, ""},
+		{"a.flac", "\x00\x01\x02\x03", "audio/flac"}, // Not sniffable, trust the name.
+		{"a.bin", "\x00\x01\x02\x03", ""},
+		{"a.pdf", "%PDF-1.4", "application/pdf"},
+		{"a.txt", "hello", ""},
+	} {
+		if typ := inlineType(c.name, http.DetectContentType([]byte(c.content))); typ != c.typ {
+			t.Fatal(c.name, typ)
+		}
+	}
+}
+
+func TestPreviewKind(t *testing.T) {
+	for name, kind := range map[string]string{
+		"a.JPG":  "image",
+		"a.mp3":  "audio",
+		"a.webm": "video",
+		"a.pdf":  "pdf",
+		"a.html": "",
+		"a.svg":  "",
+		"a":      "",
+	} {
+		if k := previewKind(name); k != kind {
+			t.Fatal(name, k)
+		}
+	}
+}
+
+func TestReceiveInline(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new_task", handleNewTask)
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	const png = "\x89PNG\x0D\x0A\x1A\x0Arest of the image"
+	const html = "<html><script>alert(1)</script>"
+	const pdf = "%PDF-1.4 rest of the document"
+	resp, err := http.Post(server.URL+"/new_task", "application/json",
+		strings.NewReader(fmt.Sprintf(`[{"name":"a.png","size":%v},{"name":"b.png","size":%v},{"name":"c.pdf","size":%v}]`, len(png), len(html), len(pdf))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var newTask struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&newTask); err != nil {
+		t.Fatal(err)
+	}
+	defer task.Query(newTask.ID).CtxCancel()
+
+	receive := func(index int, content string) *http.Response {
+		go http.Post(fmt.Sprintf("%v/send_file?task=%v&secret=%v&index=%v", server.URL,
+			url.QueryEscape(newTask.ID), url.QueryEscape(newTask.Secret), index), "", strings.NewReader(content))
+		resp, err := http.Get(fmt.Sprintf("%v/r/%v?index=%v&disposition=inline", server.URL, newTask.ID, index))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil || string(b) != content {
+			t.Fatal(err, string(b))
+		}
+		return resp
+	}
+
+	resp = receive(0, png)
+	if ct, cd, csp := resp.Header.Get("Content-Type"), resp.Header.Get("Content-Disposition"), resp.Header.Get("Content-Security-Policy"); ct != "image/png" ||
+		cd != `inline; filename*=utf-8''a.png` || !strings.HasPrefix(csp, "sandbox") {
+		t.Fatal(ct, cd, csp)
+	}
+	// Not really an image.
+	resp = receive(1, html)
+	if ct, cd := resp.Header.Get("Content-Type"), resp.Header.Get("Content-Disposition"); ct != "application/octet-stream" ||
+		cd != `attachment; filename*=utf-8''b.png` {
+		t.Fatal(ct, cd)
+	}
+	// The PDF viewer of Chromium doesn't render in a sandbox.
+	resp = receive(2, pdf)
+	if ct, csp := resp.Header.Get("Content-Type"), resp.Header.Get("Content-Security-Policy"); ct != "application/pdf" ||
+		csp == "" || strings.Contains(csp, "sandbox") {
+		t.Fatal(ct, csp)
+	}
+
+	// Preview page.
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%v/r/%v?index=0&preview", server.URL, newTask.ID), nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	// Nothing loads the file before the receiver chooses.
+	if page := string(b); !strings.Contains(page, `href="?index=0&disposition=inline"`) ||
+		!strings.Contains(page, `href="?index=0"`) || strings.Contains(page, "<img") {
+		t.Fatal(page)
+	}
+}
+
+func TestReceivePreviewable(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	const png = "\x89PNG\x0D\x0A\x1A\x0Arest of the image"
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a.png", Size: int64(len(png))}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+
+	// A browser opening a single previewable file downloads it.
+	go http.Post(server.URL+"/send_file?task="+tk.ID()+"&secret=secret&index=0", "", strings.NewReader(png))
+	req, _ := http.NewRequest("GET", server.URL+"/r/"+tk.ID(), nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); string(b) != png || resp.Header.Get("Content-Disposition") != `attachment; filename*=utf-8''a.png` {
+		t.Fatal(resp.Header, string(b))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// basePathHandler serves h under base path.
+// The base path is removed from the request path before calling h.
+func basePathHandler(base string, h http.Handler) http.Handler {
+	if base == "" {
+		return h
+	}
+	mux := http.NewServeMux()
+	// The mux redirects base to base + "/".
+	mux.Handle(base+"/", http.StripPrefix(base, h))
+	return mux
+}
+
+// remoteIP returns the IP address of the peer of r.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isTrustedProxy returns whether ip is in trusted_proxies.
+func (c *config) isTrustedProxy(ip string) bool {
+	if len(c.TrustedProxies) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range c.TrustedProxies {
+		if prefix, err := parsePrefix(p); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix parses an IP address or a CIDR prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// clientIP returns the IP address of the client of r.
+// If the peer is a trusted proxy, the client IP is the rightmost address
+// in X-Forwarded-For that is not a trusted proxy.
+func clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	c := getConfig()
+	if !c.isTrustedProxy(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		ip = addr.Unmap().String()
+		if !c.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// firstHeaderValue returns the first value of a comma separated header.
+func firstHeaderValue(h http.Header, key string) string {
+	v, _, _ := strings.Cut(h.Get(key), ",")
+	return strings.TrimSpace(v)
+}
+
+// publicURL returns the URL of path p seen by the client of r.
+// If the peer is a trusted proxy, X-Forwarded-Proto and X-Forwarded-Host are used.
+func publicURL(r *http.Request, p string) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	c := getConfig()
+	if c.isTrustedProxy(remoteIP(r)) {
+		if proto := firstHeaderValue(r.Header, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := firstHeaderValue(r.Header, "X-Forwarded-Host"); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+	return (&url.URL{Scheme: scheme, Host: host, Path: c.BasePath + p}).String()
+}
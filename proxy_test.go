@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasePathHandler(t *testing.T) {
+	handler := basePathHandler("/webfs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/webfs/r/ABC", nil))
+	if body := w.Body.String(); body != "/r/ABC" {
+		t.Fatal(body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/webfs", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/webfs/" {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/r/ABC", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Add("X-Forwarded-For", "198.51.100.1, 192.0.2.1")
+	r.Header.Add("X-Forwarded-For", "10.0.0.2")
+
+	// Untrusted proxy.
+	if ip := clientIP(r); ip != "10.0.0.1" {
+		t.Fatal(ip)
+	}
+
+	setConfig(t, func(c *config) { c.TrustedProxies = stringList{"10.0.0.0/8"} })
+	if ip := clientIP(r); ip != "192.0.2.1" {
+		t.Fatal(ip)
+	}
+
+	setConfig(t, func(c *config) { c.TrustedProxies = stringList{"10.0.0.0/8", "192.0.2.1"} })
+	if ip := clientIP(r); ip != "198.51.100.1" {
+		t.Fatal(ip)
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	r := httptest.NewRequest("GET", "/new_task", nil)
+	r.Host = "internal:8080"
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "example.com")
+
+	setConfig(t, func(c *config) { c.BasePath = "/webfs" })
+	if u := publicURL(r, "/r/ABC"); u != "http://internal:8080/webfs/r/ABC" {
+		t.Fatal(u)
+	}
+	r.TLS = &tls.ConnectionState{}
+	if u := publicURL(r, "/r/ABC"); u != "https://internal:8080/webfs/r/ABC" {
+		t.Fatal(u)
+	}
+	r.TLS = nil
+
+	setConfig(t, func(c *config) { c.TrustedProxies = stringList{"10.0.0.1"} })
+	if u := publicURL(r, "/r/ABC"); u != "https://example.com/webfs/r/ABC" {
+		t.Fatal(u)
+	}
+}
+
+func TestBasePathConfig(t *testing.T) {
+	for path, valid := range map[string]bool{
+		"":         true,
+		"/webfs":   true,
+		"/a/b":     true,
+		"webfs":    false,
+		"/webfs/":  false,
+		"/a//b":    false,
+		"/webfs?a": false,
+		"/a/../b":  false,
+	} {
+		c := defaultConfig()
+		c.BasePath = path
+		if err := c.validate(); (err == nil) != valid {
+			t.Fatal(path, err)
+		}
+	}
+}
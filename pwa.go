@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+)
+
+// handleServiceWorker serves the service worker script.
+// It is served at the root, so that its scope covers all pages.
+func handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	// Browsers check updates of the service worker with the server.
+	w.Header().Set("Cache-Control", "no-cache")
+	r.URL.Path = "static/sw.js"
+	getTheme().static.ServeHTTP(w, r)
+}
+
+// handleShare handles the shares of the Web Share Target which are not
+// taken by the service worker, such as the first share before the
+// service worker is activated. The shared content is lost, the send page
+// asks the user to choose again.
+func handleShare(w http.ResponseWriter, r *http.Request) {
+	// The Location is relative, so that the base path is kept.
+	w.Header().Set("Location", "send?share=failed")
+	w.WriteHeader(http.StatusSeeOther)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	data, err := fs.ReadFile(staticFiles, "static/res/manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest struct {
+		StartURL string `json:"start_url"`
+		Icons    []struct {
+			Src string `json:"src"`
+		} `json:"icons"`
+		ShareTarget struct {
+			Action  string `json:"action"`
+			Method  string `json:"method"`
+			Enctype string `json:"enctype"`
+		} `json:"share_target"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	// URLs are relative to the manifest, so that the base path is kept.
+	if manifest.StartURL != "../" || manifest.ShareTarget.Action != "../share" ||
+		manifest.ShareTarget.Method != "POST" || manifest.ShareTarget.Enctype != "multipart/form-data" {
+		t.Fatalf("%+v", manifest)
+	}
+	if len(manifest.Icons) == 0 {
+		t.Fatal("no icon")
+	}
+	for _, icon := range manifest.Icons {
+		if _, err := fs.Stat(staticFiles, path.Join("static/res", icon.Src)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestPWA(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleServiceWorker(w, httptest.NewRequest("GET", "/sw.js", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") ||
+		w.Header().Get("Cache-Control") != "no-cache" || !strings.Contains(w.Body.String(), `"fetch"`) {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handleShare(w, httptest.NewRequest("POST", "/share", strings.NewReader("")))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "send?share=failed" {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	for _, h := range []http.HandlerFunc{handleIndex, handleSend, handleReceive} {
+		w = httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/", nil))
+		if body := w.Body.String(); !strings.Contains(body, `<link rel="manifest" href="/res/manifest.json">`) ||
+			!strings.Contains(body, `register("/" + "sw.js")`) {
+			t.Fatal(body)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mkch/webfs/qrcode"
+	"github.com/mkch/webfs/task"
+)
+
+// qrScale is the size of a module of PNG QR codes in pixels.
+const qrScale = 8
+
+// handleQR responds the QR code of the receiving URL of a task.
+//
+//	GET /qr/<id>.svg
+//	GET /qr/<id>.png
+func handleQR(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	ext := path.Ext(name)
+	if ext != ".svg" && ext != ".png" {
+		http.NotFound(w, r)
+		return
+	}
+	t := task.Query(strings.TrimSuffix(name, ext))
+	if t == nil {
+		failedLookups.Inc()
+		// Increase the cost of brute force.
+		time.Sleep(time.Duration(getConfig().TaskFailDelay))
+		httpError(w, r, http.StatusNotFound, "error.no_such_task")
+		return
+	}
+	code, err := qrcode.Encode([]byte(publicURL(r, "/r/"+t.ID())), qrcode.M)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "private")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if ext == ".svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = code.WriteSVG(w)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		err = code.WritePNG(w, qrScale)
+	}
+	if err != nil {
+		requestLogger(r).Debug("failed to write QR code", "error", err)
+	}
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// printQR prints the QR code of url to w.
+func printQR(w io.Writer, url string) {
+	code, err := qrcode.Encode([]byte(url), qrcode.L)
+	if err != nil {
+		return
+	}
+	fmt.Fprint(w, code.Terminal())
+	fmt.Fprintln(w, url)
+}
+
+// serverURL returns the URL of the home page of the server listening on addr,
+// which can be used by other devices in the local network.
+func serverURL(addr net.Addr, basePath string) string {
+	host, port := "localhost", "80"
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		port = strconv.Itoa(tcp.Port)
+		if !tcp.IP.IsUnspecified() {
+			host = tcp.IP.String()
+		} else if ip := localIP(); ip != nil {
+			host = ip.String()
+		}
+	}
+	return "http://" + net.JoinHostPort(host, port) + basePath + "/"
+}
+
+// localIP returns an IPv4 address of this host in the local network,
+// or nil if not found.
+func localIP() net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsPrivate() {
+			if ip := ipNet.IP.To4(); ip != nil {
+				return ip
+			}
+		}
+	}
+	return nil
+}
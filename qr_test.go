@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+func TestQR(t *testing.T) {
+	setConfig(t, func(c *config) { c.TaskFailDelay = 0 })
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a", Size: 1}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+
+	w := httptest.NewRecorder()
+	handleQR(w, httptest.NewRequest("GET", "/qr/"+tk.ID()+".svg", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" || !strings.HasPrefix(w.Body.String(), "<svg ") {
+		t.Fatal(w.Code, w.Header(), w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handleQR(w, httptest.NewRequest("GET", "/qr/"+tk.ID()+".png", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if _, err := png.Decode(bytes.NewReader(w.Body.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"/qr/" + tk.ID() + ".gif", "/qr/NOSUCH.svg"} {
+		w = httptest.NewRecorder()
+		handleQR(w, httptest.NewRequest("GET", p, nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatal(p, w.Code)
+		}
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	if u := serverURL(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 8080}, "/webfs"); u != "http://192.0.2.1:8080/webfs/" {
+		t.Fatal(u)
+	}
+	if u := serverURL(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 80}, ""); u != "http://[::1]:80/" {
+		t.Fatal(u)
+	}
+	if u := serverURL(&net.TCPAddr{IP: net.IPv4zero, Port: 80}, ""); !strings.HasPrefix(u, "http://") || !strings.HasSuffix(u, ":80/") {
+		t.Fatal(u)
+	}
+}
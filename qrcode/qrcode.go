@@ -0,0 +1,252 @@
+// Package qrcode implements a QR code encoder.
+//
+// Data is encoded in byte mode with the smallest version that fits,
+// and the mask with the lowest penalty defined by ISO/IEC 18004.
+package qrcode
+
+import (
+	"errors"
+)
+
+// Level is the error correction level.
+type Level int
+
+const (
+	L Level = iota // Recovers 7% of data.
+	M              // Recovers 15% of data.
+	Q              // Recovers 25% of data.
+	H              // Recovers 30% of data.
+)
+
+// formatBits are the bits of levels in the format information.
+var formatBits = [...]int{L: 1, M: 0, Q: 3, H: 2}
+
+// eccCodewordsPerBlock is indexed by level and version.
+var eccCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// numBlocks is the number of error correction blocks indexed by level and version.
+var numBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+const minVersion, maxVersion = 1, 40
+
+// ErrTooLong is returned if the data doesn't fit in a QR code.
+var ErrTooLong = errors.New("qrcode: data too long")
+
+// Code is a QR code.
+type Code struct {
+	Size    int // Number of modules in a row or column.
+	modules []bool
+}
+
+// Black reports whether the module at column x and row y is dark.
+// Modules out of the code, including the quiet zone, are light.
+func (c *Code) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y*c.Size+x]
+}
+
+// Encode encodes data into a QR code.
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < L || level > H {
+		return nil, errors.New("qrcode: invalid level")
+	}
+	for version := minVersion; version <= maxVersion; version++ {
+		if dataBits(version, len(data)) <= numDataCodewords(version, level)*8 {
+			return encode(data, level, version, -1), nil
+		}
+	}
+	return nil, ErrTooLong
+}
+
+// dataBits returns the number of bits of n bytes in byte mode.
+func dataBits(version, n int) int {
+	countBits := 8
+	if version > 9 {
+		countBits = 16
+	}
+	if n >= 1<<countBits {
+		return 1 << 30
+	}
+	return 4 + countBits + n*8
+}
+
+// encode encodes data into a QR code of version with mask.
+// The mask with the lowest penalty is used if mask is -1.
+func encode(data []byte, level Level, version, mask int) *Code {
+	codewords := addECC(dataCodewords(data, level, version), level, version)
+
+	s := newSymbol(version)
+	s.drawFunctionPatterns()
+	s.drawCodewords(codewords)
+
+	if mask < 0 {
+		minPenalty := 0
+		for m := 0; m < 8; m++ {
+			s.applyMask(m)
+			s.drawFormatBits(level, m)
+			if p := s.penalty(); mask < 0 || p < minPenalty {
+				mask, minPenalty = m, p
+			}
+			// Masking twice restores the modules.
+			s.applyMask(m)
+		}
+	}
+	s.applyMask(mask)
+	s.drawFormatBits(level, mask)
+	return &Code{Size: s.size, modules: s.modules}
+}
+
+// dataCodewords returns the data codewords of data, including the
+// header, the terminator and the padding.
+func dataCodewords(data []byte, level Level, version int) []byte {
+	var bb bitBuffer
+	bb.append(0b0100, 4) // Byte mode.
+	if version > 9 {
+		bb.append(len(data), 16)
+	} else {
+		bb.append(len(data), 8)
+	}
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+	capacity := numDataCodewords(version, level) * 8
+	bb.append(0, min(4, capacity-bb.len))
+	bb.append(0, (8-bb.len%8)%8)
+	for pad := 0xEC; bb.len < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+	return bb.bytes
+}
+
+type bitBuffer struct {
+	bytes []byte
+	len   int // Number of bits.
+}
+
+// append appends the lower n bits of v.
+func (bb *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if bb.len%8 == 0 {
+			bb.bytes = append(bb.bytes, 0)
+		}
+		if v>>i&1 != 0 {
+			bb.bytes[bb.len/8] |= 0x80 >> (bb.len % 8)
+		}
+		bb.len++
+	}
+}
+
+// numRawDataModules returns the number of modules for data and error
+// correction codewords, including the remainder bits.
+func numRawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		n -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// numDataCodewords returns the number of data codewords.
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*numBlocks[level][version]
+}
+
+// addECC splits data into blocks, adds error correction codewords to each
+// block and interleaves the blocks.
+func addECC(data []byte, level Level, version int) []byte {
+	nBlocks := numBlocks[level][version]
+	eccLen := eccCodewordsPerBlock[level][version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := nBlocks - rawCodewords%nBlocks
+	shortBlockLen := rawCodewords / nBlocks
+
+	generator := rsGenerator(eccLen)
+	blocks := make([][]byte, 0, nBlocks)
+	for i, k := 0, 0; i < nBlocks; i++ {
+		dataLen := shortBlockLen - eccLen
+		if i >= numShortBlocks {
+			dataLen++
+		}
+		blocks = append(blocks, append(data[k:k+dataLen:k+dataLen], rsRemainder(data[k:k+dataLen], generator)...))
+		k += dataLen
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i <= shortBlockLen; i++ {
+		for j, block := range blocks {
+			// Short blocks have no data codeword at shortBlockLen-eccLen.
+			if j < numShortBlocks {
+				if i == shortBlockLen-eccLen {
+					continue
+				}
+				if i > shortBlockLen-eccLen {
+					result = append(result, block[i-1])
+					continue
+				}
+			}
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) with the polynomial 0x11D.
+func gfMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		hi := z >> 7
+		z <<= 1
+		z ^= hi * 0x1D
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// rsGenerator returns the coefficients of the Reed-Solomon generator
+// polynomial of degree, from the highest to the lowest power, excluding
+// the leading 1.
+func rsGenerator(degree int) []byte {
+	coef := make([]byte, degree)
+	coef[degree-1] = 1
+	var root byte = 1
+	for i := 0; i < degree; i++ {
+		// Multiply by (x - root).
+		for j := range coef {
+			coef[j] = gfMul(coef[j], root)
+			if j+1 < len(coef) {
+				coef[j] ^= coef[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return coef
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of data.
+func rsRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, g := range generator {
+			result[i] ^= gfMul(g, factor)
+		}
+	}
+	return result
+}
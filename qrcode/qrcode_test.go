@@ -0,0 +1,130 @@
+package qrcode_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/mkch/webfs/qrcode"
+)
+
+func TestEncode(t *testing.T) {
+	golden := []string{
+		"#######...#...#######",
+		"#.....#..##.#.#.....#",
+		"#.###.#.###.#.#.###.#",
+		"#.###.#.#.#...#.###.#",
+		"#.###.#.#.#.#.#.###.#",
+		"#.....#.##.#..#.....#",
+		"#######.#.#.#.#######",
+		"........##...........",
+		"#.#####..#.#..#####..",
+		".#..##.########..##.#",
+		".##...#.....#.##.###.",
+		".#..##.########..####",
+		"..#...#.....#..#...#.",
+		"........#...#..#..###",
+		"#######..###.#..#..#.",
+		"#.....#.##.....#####.",
+		"#.###.#.#.##.#..#..#.",
+		"#.###.#.##.#####.##..",
+		"#.###.#.#...#.##.....",
+		"#.....#....####.###..",
+		"#######.#...#..#.#.#.",
+	}
+	c, err := qrcode.Encode([]byte("webfs"), qrcode.M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size != len(golden) {
+		t.Fatal(c.Size)
+	}
+	for y, row := range golden {
+		for x, m := range row {
+			if c.Black(x, y) != (m == '#') {
+				t.Fatalf("module %v,%v", x, y)
+			}
+		}
+	}
+	if c.Black(-1, 0) || c.Black(0, c.Size) {
+		t.Fatal("quiet zone is dark")
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	for _, c := range []struct {
+		level qrcode.Level
+		n     int // Max bytes.
+		size  int
+	}{
+		{qrcode.L, 17, 21},
+		{qrcode.M, 14, 21},
+		{qrcode.Q, 11, 21},
+		{qrcode.H, 7, 21},
+		{qrcode.M, 213, 57},
+		{qrcode.L, 2953, 177},
+		{qrcode.H, 1273, 177},
+	} {
+		code, err := qrcode.Encode(make([]byte, c.n), c.level)
+		if err != nil {
+			t.Fatal(c, err)
+		}
+		if code.Size != c.size {
+			t.Fatal(c, code.Size)
+		}
+		code, err = qrcode.Encode(make([]byte, c.n+1), c.level)
+		if c.size == 177 {
+			if err != qrcode.ErrTooLong {
+				t.Fatal(c, err)
+			}
+		} else if err != nil || code.Size != c.size+4 {
+			t.Fatal(c, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := qrcode.Encode([]byte("https://example.com/r/ABC"), qrcode.M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := c.Size + qrcode.QuietZone*2
+
+	var buf bytes.Buffer
+	if err := c.WritePNG(&buf, 3); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != full*3 || b.Dy() != full*3 {
+		t.Fatal(b)
+	}
+	// The top left module of the finder pattern.
+	if r, _, _, _ := img.At(qrcode.QuietZone*3, qrcode.QuietZone*3).RGBA(); r != 0 {
+		t.Fatal(r)
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Fatal(r)
+	}
+
+	buf.Reset()
+	if err := c.WriteSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if svg := buf.String(); !strings.HasPrefix(svg, "<svg ") || !strings.HasSuffix(svg, "</svg>") ||
+		!strings.Contains(svg, `d="M4 4h7v1h-7z`) {
+		t.Fatal(svg)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(c.Terminal(), "\n"), "\n")
+	if len(lines) != (full+1)/2 {
+		t.Fatal(len(lines))
+	}
+	// Top of the finder patterns.
+	if !strings.Contains(lines[2], "█▀▀▀▀▀█") {
+		t.Fatal(lines[2])
+	}
+}
@@ -0,0 +1,85 @@
+package qrcode
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// QuietZone is the width of the light border around a rendered code in modules.
+const QuietZone = 4
+
+// Image returns the image of c with the quiet zone.
+// Every module is scale pixels wide.
+func (c *Code) Image(scale int) *image.Paletted {
+	scale = max(scale, 1)
+	size := (c.Size + QuietZone*2) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.Black(x/scale-QuietZone, y/scale-QuietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// WritePNG writes c to w as a PNG image. See Image for scale.
+func (c *Code) WritePNG(w io.Writer, scale int) error {
+	return png.Encode(w, c.Image(scale))
+}
+
+// WriteSVG writes c to w as a SVG image with the quiet zone.
+// The image is scalable, one unit of the view box is a module.
+func (c *Code) WriteSVG(w io.Writer) error {
+	size := c.Size + QuietZone*2
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %[1]v %[1]v" shape-rendering="crispEdges">`, size)
+	fmt.Fprintf(bw, `<rect width="%[1]v" height="%[1]v" fill="#fff"/><path fill="#000" d="`, size)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.Black(x, y) {
+				x++
+				continue
+			}
+			// Draw a run of dark modules as a rectangle.
+			run := 1
+			for c.Black(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(bw, "M%v %vh%vv1h-%vz", x+QuietZone, y+QuietZone, run, run)
+			x += run
+		}
+	}
+	bw.WriteString(`"/></svg>`)
+	return bw.Flush()
+}
+
+// Terminal returns c drawn with Unicode half blocks, two rows of modules
+// in a line of text. ANSI escape codes are used to draw dark modules
+// on light background regardless of the colors of the terminal.
+func (c *Code) Terminal() string {
+	var sb strings.Builder
+	for y := -QuietZone; y < c.Size+QuietZone; y += 2 {
+		sb.WriteString("\x1b[30;47m")
+		for x := -QuietZone; x < c.Size+QuietZone; x++ {
+			switch top, bottom := c.Black(x, y), c.Black(x, y+1); {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,285 @@
+package qrcode
+
+// symbol is a QR code being drawn.
+type symbol struct {
+	version    int
+	size       int
+	modules    []bool // Dark modules, indexed by y*size+x.
+	isFunction []bool // Modules of function patterns, which are not masked.
+}
+
+func newSymbol(version int) *symbol {
+	size := version*4 + 17
+	return &symbol{
+		version:    version,
+		size:       size,
+		modules:    make([]bool, size*size),
+		isFunction: make([]bool, size*size),
+	}
+}
+
+func (s *symbol) get(x, y int) bool {
+	return s.modules[y*s.size+x]
+}
+
+func (s *symbol) setFunction(x, y int, dark bool) {
+	s.modules[y*s.size+x] = dark
+	s.isFunction[y*s.size+x] = true
+}
+
+func (s *symbol) drawFunctionPatterns() {
+	// Timing patterns.
+	for i := 0; i < s.size; i++ {
+		s.setFunction(6, i, i%2 == 0)
+		s.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators.
+	s.drawFinder(3, 3)
+	s.drawFinder(s.size-4, 3)
+	s.drawFinder(3, s.size-4)
+
+	// Alignment patterns, except the ones overlapping finder patterns.
+	pos := alignmentPositions(s.version)
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			s.drawAlignment(pos[i], pos[j])
+		}
+	}
+
+	// Reserve the format bits. They are drawn after masking.
+	s.drawFormatBits(0, 0)
+	s.drawVersion()
+}
+
+// drawFinder draws a finder pattern and its separator centered at x, y.
+func (s *symbol) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= s.size || yy < 0 || yy >= s.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy)) // Chebyshev distance.
+			s.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignment draws an alignment pattern centered at x, y.
+func (s *symbol) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			s.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// alignmentPositions returns the center coordinates of alignment patterns
+// in ascending order.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	pos := make([]int, numAlign)
+	pos[0] = 6
+	for i, p := numAlign-1, version*4+10; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// drawFormatBits draws the format information of level and mask.
+func (s *symbol) drawFormatBits(level Level, mask int) {
+	data := formatBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	// Around the top left finder pattern.
+	for i := 0; i <= 5; i++ {
+		s.setFunction(8, i, bit(i))
+	}
+	s.setFunction(8, 7, bit(6))
+	s.setFunction(8, 8, bit(7))
+	s.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		s.setFunction(14-i, 8, bit(i))
+	}
+
+	// Around the other finder patterns.
+	for i := 0; i < 8; i++ {
+		s.setFunction(s.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		s.setFunction(8, s.size-15+i, bit(i))
+	}
+	// The dark module.
+	s.setFunction(8, s.size-8, true)
+}
+
+// drawVersion draws the version information of version 7 and above.
+func (s *symbol) drawVersion() {
+	if s.version < 7 {
+		return
+	}
+	rem := s.version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := s.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := s.size-11+i%3, i/3
+		s.setFunction(a, b, dark)
+		s.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords draws codewords in the zigzag order.
+func (s *symbol) drawCodewords(codewords []byte) {
+	i := 0
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Skip the vertical timing pattern.
+			right = 5
+		}
+		for vert := 0; vert < s.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if upward := (right+1)&2 == 0; upward {
+					y = s.size - 1 - vert
+				}
+				if s.isFunction[y*s.size+x] {
+					continue
+				}
+				// The remainder bits are light.
+				if i < len(codewords)*8 {
+					s.modules[y*s.size+x] = codewords[i/8]>>(7-i%8)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the non-function modules selected by mask.
+func (s *symbol) applyMask(mask int) {
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !s.isFunction[y*s.size+x] {
+				s.modules[y*s.size+x] = !s.modules[y*s.size+x]
+			}
+		}
+	}
+}
+
+// Weights of the penalty rules.
+const (
+	penaltyRun    = 3
+	penaltyBlock  = 3
+	penaltyFinder = 40
+	penaltyRatio  = 10
+)
+
+// penalty returns the penalty score of the current modules.
+func (s *symbol) penalty() int {
+	p := 0
+	for i := 0; i < s.size; i++ {
+		p += s.linePenalty(func(j int) bool { return s.get(j, i) })
+		p += s.linePenalty(func(j int) bool { return s.get(i, j) })
+	}
+
+	// 2x2 blocks of the same color.
+	for y := 0; y < s.size-1; y++ {
+		for x := 0; x < s.size-1; x++ {
+			c := s.get(x, y)
+			if c == s.get(x+1, y) && c == s.get(x, y+1) && c == s.get(x+1, y+1) {
+				p += penaltyBlock
+			}
+		}
+	}
+
+	// Balance of dark and light modules.
+	dark := 0
+	for _, m := range s.modules {
+		if m {
+			dark++
+		}
+	}
+	total := len(s.modules)
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	p += k * penaltyRatio
+	return p
+}
+
+// finderLike is the 1:1:3:1:1 pattern with 4 light modules on one side.
+var finderLike = [2][11]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// linePenalty returns the penalty of runs and finder-like patterns
+// in a row or column. at returns the module at index.
+func (s *symbol) linePenalty(at func(int) bool) int {
+	p := 0
+	run := 1
+	for i := 1; i <= s.size; i++ {
+		if i < s.size && at(i) == at(i-1) {
+			run++
+			continue
+		}
+		if run >= 5 {
+			p += penaltyRun + run - 5
+		}
+		run = 1
+	}
+	for i := 0; i+11 <= s.size; i++ {
+	pattern:
+		for _, pattern := range finderLike {
+			for j, dark := range pattern {
+				if at(i+j) != dark {
+					continue pattern
+				}
+			}
+			p += penaltyFinder
+		}
+	}
+	return p
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/mkch/webfs/ratelimit"
+	"github.com/mkch/webfs/task"
+)
+
+// globalBucket limits the total relay rate.
+var globalBucket = ratelimit.NewBucket(0)
+
+// clientBuckets limit the relay rate of each client IP.
+var clientBuckets = ratelimit.NewGroup(0)
+
+// taskBuckets limit the relay rate of each task.
+var taskBuckets = ratelimit.NewGroup(0)
+
+// taskRateLimit returns the rate limit of a new task requested by r.
+// The rate_limit query parameter, in bytes per second, is capped by
+// the task_rate_limit setting.
+func taskRateLimit(r *http.Request) (int64, bool) {
+	max := getConfig().TaskRateLimit
+	query := r.URL.Query()
+	if !query.Has("rate_limit") {
+		return max, true
+	}
+	rate, err := strconv.ParseInt(query.Get("rate_limit"), 10, 64)
+	if err != nil || rate <= 0 {
+		return 0, false
+	}
+	if max > 0 && rate > max {
+		rate = max
+	}
+	return rate, true
+}
+
+// limitRelay returns a reader that reads the content of file in t no faster
+// than the global, per-client and per-task limits.
+// release must be called after relaying.
+func limitRelay(ctx context.Context, r io.Reader, t *task.Task, file *task.File, receiverIP string) (reader io.Reader, release func()) {
+	taskBucket, releaseTask := taskBuckets.GetRate(t.ID(), t.RateLimit())
+	receiverBucket, releaseReceiver := clientBuckets.Get(receiverIP)
+	buckets := []*ratelimit.Bucket{globalBucket, taskBucket, receiverBucket}
+	releaseSender := func() {}
+	if senderIP := file.Status().SenderIP; senderIP != receiverIP {
+		var senderBucket *ratelimit.Bucket
+		senderBucket, releaseSender = clientBuckets.Get(senderIP)
+		buckets = append(buckets, senderBucket)
+	}
+	return ratelimit.NewReader(ctx, r, buckets...), func() {
+		releaseTask()
+		releaseReceiver()
+		releaseSender()
+	}
+}
@@ -0,0 +1,219 @@
+// Package ratelimit implements token bucket bandwidth limiting.
+//
+// Tokens are reserved in FIFO order and the token count of a Bucket can go
+// negative, so concurrent readers that read in equal chunks share the
+// bandwidth of a Bucket fairly.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket. A token is a byte.
+type Bucket struct {
+	l      sync.Mutex
+	rate   float64 // Tokens per second.
+	burst  float64 // Max tokens.
+	tokens float64 // Available tokens, negative if reserved in advance.
+	last   time.Time
+}
+
+// NewBucket creates a full Bucket refilled by rate tokens per second.
+// A Bucket with non-positive rate never blocks.
+func NewBucket(rate int64) *Bucket {
+	b := &Bucket{last: time.Now()}
+	b.setRate(rate)
+	b.tokens = b.burst
+	return b
+}
+
+// burstDuration is how long a Bucket can accumulate tokens.
+const burstDuration = time.Millisecond * 100
+
+func (b *Bucket) setRate(rate int64) {
+	b.rate = float64(rate)
+	b.burst = b.rate * burstDuration.Seconds()
+	if b.burst < 1 {
+		b.burst = 1
+	}
+}
+
+// Rate returns the rate of b.
+func (b *Bucket) Rate() int64 {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return int64(b.rate)
+}
+
+// SetRate changes the rate of b.
+func (b *Bucket) SetRate(rate int64) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.refill(time.Now())
+	b.setRate(rate)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+func (b *Bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// reserve takes n tokens and returns the duration to wait before using them.
+func (b *Bucket) reserve(now time.Time, n int) time.Duration {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// WaitN waits until n tokens are available in all the buckets.
+// Nil buckets are ignored.
+func WaitN(ctx context.Context, n int, buckets ...*Bucket) error {
+	now := time.Now()
+	var wait time.Duration
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		if d := b.reserve(now, n); d > wait {
+			wait = d
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// maxChunk is the max number of bytes read at a time by Reader.
+const maxChunk = 16 * 1024
+
+// reader limits the reading rate of an io.Reader.
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*Bucket
+}
+
+// NewReader returns a reader that reads from r no faster than
+// any of buckets allows. Nil buckets are ignored.
+// Read returns the error of ctx if ctx is done while waiting.
+func NewReader(ctx context.Context, r io.Reader, buckets ...*Bucket) io.Reader {
+	var nonNil []*Bucket
+	for _, b := range buckets {
+		if b != nil {
+			nonNil = append(nonNil, b)
+		}
+	}
+	if len(nonNil) == 0 {
+		return r
+	}
+	return &reader{ctx, r, nonNil}
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err = r.r.Read(p)
+	if n > 0 {
+		if waitErr := WaitN(r.ctx, n, r.buckets...); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return
+}
+
+// Group is a set of buckets with the same rate indexed by key.
+// A bucket exists while it is in use.
+type Group struct {
+	l       sync.Mutex
+	rate    int64
+	buckets map[string]*groupBucket
+}
+
+type groupBucket struct {
+	*Bucket
+	refs int
+}
+
+// NewGroup creates a Group with rate of buckets.
+func NewGroup(rate int64) *Group {
+	return &Group{rate: rate, buckets: make(map[string]*groupBucket)}
+}
+
+// SetRate changes the rate of all buckets in g.
+func (g *Group) SetRate(rate int64) {
+	g.l.Lock()
+	defer g.l.Unlock()
+	g.rate = rate
+	for _, b := range g.buckets {
+		b.SetRate(rate)
+	}
+}
+
+// Get returns the bucket of key. The bucket is created if not exists.
+// release must be called when the bucket is no longer used.
+// If rate of g is not positive, nil bucket is returned.
+func (g *Group) Get(key string) (bucket *Bucket, release func()) {
+	g.l.Lock()
+	rate := g.rate
+	g.l.Unlock()
+	return g.GetRate(key, rate)
+}
+
+// GetRate is like Get, but uses rate for the bucket if it is created.
+func (g *Group) GetRate(key string, rate int64) (bucket *Bucket, release func()) {
+	if rate <= 0 {
+		return nil, func() {}
+	}
+	g.l.Lock()
+	defer g.l.Unlock()
+	b := g.buckets[key]
+	if b == nil {
+		b = &groupBucket{Bucket: NewBucket(rate)}
+		g.buckets[key] = b
+	}
+	b.refs++
+	var once sync.Once
+	return b.Bucket, func() {
+		once.Do(func() {
+			g.l.Lock()
+			defer g.l.Unlock()
+			b.refs--
+			if b.refs == 0 {
+				delete(g.buckets, key)
+			}
+		})
+	}
+}
+
+// Len returns the number of buckets in use.
+func (g *Group) Len() int {
+	g.l.Lock()
+	defer g.l.Unlock()
+	return len(g.buckets)
+}
@@ -0,0 +1,107 @@
+package ratelimit_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/ratelimit"
+)
+
+func TestReader(t *testing.T) {
+	const rate = 100 * 1024
+	b := ratelimit.NewBucket(rate)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, ratelimit.NewReader(context.Background(), strings.NewReader(strings.Repeat("a", rate/2)), b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != rate/2 {
+		t.Fatal(n)
+	}
+	// Half a second minus the burst.
+	if d := time.Since(start); d < time.Millisecond*350 || d > time.Second {
+		t.Fatal(d)
+	}
+}
+
+func TestReaderCancel(t *testing.T) {
+	b := ratelimit.NewBucket(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := io.Copy(io.Discard, ratelimit.NewReader(ctx, strings.NewReader("abcdef"), b))
+	if err != context.Canceled {
+		t.Fatal(err)
+	}
+}
+
+func TestNoLimit(t *testing.T) {
+	r := strings.NewReader("abc")
+	if lr := ratelimit.NewReader(context.Background(), r, nil, nil); lr != io.Reader(r) {
+		t.Fatal(lr)
+	}
+	if err := ratelimit.WaitN(context.Background(), 1<<30, ratelimit.NewBucket(0)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFairSharing(t *testing.T) {
+	const rate = 200 * 1024
+	b := ratelimit.NewBucket(rate)
+	var wg sync.WaitGroup
+	var durations [2]time.Duration
+	start := time.Now()
+	for i := range durations {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			io.Copy(io.Discard, ratelimit.NewReader(context.Background(), strings.NewReader(strings.Repeat("a", rate/4)), b))
+			durations[i] = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+	// Both readers finish at about the same time.
+	diff := durations[0] - durations[1]
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > time.Millisecond*150 {
+		t.Fatal(durations)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	g := ratelimit.NewGroup(100)
+	b1, release1 := g.Get("a")
+	b2, release2 := g.Get("a")
+	if b1 != b2 || g.Len() != 1 {
+		t.Fatal(b1, b2)
+	}
+	b3, release3 := g.GetRate("b", 200)
+	if b3.Rate() != 200 {
+		t.Fatal(b3.Rate())
+	}
+	g.SetRate(300)
+	if b1.Rate() != 300 {
+		t.Fatal(b1.Rate())
+	}
+	release1()
+	release1() // No effect.
+	if g.Len() != 2 {
+		t.Fatal(g.Len())
+	}
+	release2()
+	release3()
+	if g.Len() != 0 {
+		t.Fatal(g.Len())
+	}
+
+	if b, release := ratelimit.NewGroup(0).Get("a"); b != nil {
+		t.Fatal(b)
+	} else {
+		release()
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTaskRateLimit(t *testing.T) {
+	setConfig(t, func(c *config) { c.TaskRateLimit = 1000 })
+	for query, expected := range map[string]int64{
+		"":                  1000,
+		"?rate_limit=100":   100,
+		"?rate_limit=10000": 1000,
+		"?rate_limit=0":     -1,
+		"?rate_limit=abc":   -1,
+	} {
+		rate, ok := taskRateLimit(httptest.NewRequest("POST", "/new_task"+query, nil))
+		if expected < 0 {
+			if ok {
+				t.Fatal(query, rate)
+			}
+		} else if !ok || rate != expected {
+			t.Fatal(query, rate, ok)
+		}
+	}
+
+	setConfig(t, func(c *config) { c.TaskRateLimit = 0 })
+	if rate, ok := taskRateLimit(httptest.NewRequest("POST", "/new_task?rate_limit=10000", nil)); !ok || rate != 10000 {
+		t.Fatal(rate, ok)
+	}
+}
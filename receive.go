@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// receiveRetries is the max times receiving a range is retried.
+const receiveRetries = 3
+
+// errTaskGone means the task is cancelled or expired.
+var errTaskGone = errors.New("task cancelled")
+
+// receiveClient is the client of the receive sub command.
+type receiveClient struct {
+	server string // URL of the server without trailing slash.
+	stdout io.Writer
+	stderr io.Writer
+	// Number of ranges received in parallel of files of at least
+	// sendSegmentThreshold bytes. Files are received as a whole if less than 2.
+	segments int
+}
+
+// runReceive runs the "receive" sub command.
+func runReceive(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("webfs receive", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", os.Getenv(serverEnv), "URL of the server, also "+serverEnv+" environment variable, discovered on the local network if empty")
+	dir := fs.String("dir", ".", "Directory to save the files in")
+	segments := fs.Int("segments", 4, fmt.Sprintf("Number of ranges received in parallel of files of at least %v MiB uploaded in segments, 1 to receive them as a whole", sendSegmentThreshold>>20))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: webfs receive [flags] code\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *server == "" {
+		var err error
+		if *server, err = defaultServer(ctx, stderr); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	c := &receiveClient{strings.TrimSuffix(*server, "/"), stdout, stderr, *segments}
+	if err := c.receive(ctx, fs.Arg(0), *dir); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// get sends a GET request of u with the Range header rng if not empty.
+// Responses other than 200 and 206 are returned as errors.
+func (c *receiveClient) get(ctx context.Context, u, rng string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errTaskGone
+	}
+	msg, _ := io.ReadAll(resp.Body)
+	return nil, fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// receive receives the files of the task code into dir,
+// or prints the text of a text task.
+func (c *receiveClient) receive(ctx context.Context, code, dir string) error {
+	taskURL := c.server + "/r/" + url.PathEscape(code)
+	resp, err := c.get(ctx, taskURL+"?status", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		_, err := io.Copy(c.stdout, resp.Body)
+		return err
+	}
+	var status fileListStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return err
+	}
+	for i := range status.Files {
+		f := &status.Files[i]
+		if err := c.receiveFile(ctx, fmt.Sprintf("%v?index=%v", taskURL, f.Index), f, dir); err != nil {
+			return fmt.Errorf("%v: %w", f.Name, err)
+		}
+		fmt.Fprintf(c.stdout, "%v received\n", f.Name)
+	}
+	return nil
+}
+
+// receiveFile receives file f from u into dir. Large files are received in
+// parallel ranges if they are uploaded in segments.
+func (c *receiveClient) receiveFile(ctx context.Context, u string, f *fileListItem, dir string) (err error) {
+	name := filepath.Base(f.Name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return errors.New("invalid file name")
+	}
+	path := filepath.Join(dir, name)
+	// Existing files are not overwritten.
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	n := 1
+	if c.segments > 1 && f.Size >= max(sendSegmentThreshold, int64(c.segments)) {
+		n = c.segments
+	}
+	rng := ""
+	_, length := segmentRange(f.Size, n, 0)
+	if n > 1 {
+		rng = fmt.Sprintf("bytes=0-%v", length-1)
+	}
+	resp, err := c.get(ctx, u, rng)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		// Uploaded as a whole, the range is ignored.
+		defer resp.Body.Close()
+		written, err := io.Copy(out, resp.Body)
+		if err == nil && f.Size >= 0 && written != f.Size {
+			err = fmt.Errorf("received %v bytes of %v", written, f.Size)
+		}
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, n)
+	go func() { errs <- c.receiveRange(ctx, u, out, 0, length, resp) }()
+	for seg := 1; seg < n; seg++ {
+		offset, length := segmentRange(f.Size, n, seg)
+		go func() { errs <- c.receiveRange(ctx, u, out, offset, length, nil) }()
+	}
+	for i := 0; i < n; i++ {
+		if rerr := <-errs; rerr != nil && err == nil {
+			err = rerr
+			cancel()
+		}
+	}
+	return err
+}
+
+// receiveRange receives length bytes at offset of the file at u into out.
+// resp is the response of the first request if not nil.
+// The remaining bytes are requested again after a failure.
+func (c *receiveClient) receiveRange(ctx context.Context, u string, out io.WriterAt, offset, length int64, resp *http.Response) error {
+	for attempt := 0; ; attempt++ {
+		var err error
+		if resp == nil {
+			resp, err = c.get(ctx, u, fmt.Sprintf("bytes=%v-%v", offset, offset+length-1))
+		}
+		if err == nil {
+			var written int64
+			written, err = copyRange(io.NewOffsetWriter(out, offset), resp, offset, length)
+			resp.Body.Close()
+			resp = nil
+			offset, length = offset+written, length-written
+		}
+		if err == nil || err == errTaskGone || ctx.Err() != nil || attempt == receiveRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sendRetryDelay):
+		}
+	}
+}
+
+// copyRange copies the range of length bytes at offset in resp to w.
+func copyRange(w io.Writer, resp *http.Response, offset, length int64) (int64, error) {
+	if resp.StatusCode != http.StatusPartialContent ||
+		!strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %v-%v/", offset, offset+length-1)) {
+		return 0, fmt.Errorf("unexpected range response: %v %v", resp.Status, resp.Header.Get("Content-Range"))
+	}
+	written, err := io.CopyN(w, resp.Body, length)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return written, err
+}
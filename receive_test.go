@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+// startSend runs the send sub command with args, and returns the code of
+// the task and the channel of the exit code.
+func startSend(t *testing.T, args ...string) (string, <-chan int) {
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	done := make(chan int, 1)
+	go func() { done <- runSend(append([]string{"-qr=false"}, args...), stdout, stderr) }()
+	for deadline := time.Now().Add(time.Second * 5); ; time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatal(stdout, stderr)
+		}
+		if m := codePattern.FindStringSubmatch(stdout.String()); m != nil {
+			return m[1], done
+		}
+	}
+}
+
+func TestReceive(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6; c.TaskFailDelay = 0 })
+	old := sendSegmentThreshold
+	sendSegmentThreshold = 1000
+	defer func() { sendSegmentThreshold = old }()
+
+	var ranges atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new_task", handleNewTask)
+	mux.HandleFunc("/cancel_task", handleCancelTask)
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	src := t.TempDir()
+	big := make([]byte, 100000)
+	rand.Read(big)
+	files := map[string][]byte{"a.txt": []byte("content of a"), "big.bin": big}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, name), content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// receive receives the task code into a new directory.
+	receive := func(code string, args ...string) string {
+		dir := t.TempDir()
+		var stdout, stderr strings.Builder
+		if exit := runReceive(append([]string{"-server", server.URL, "-dir", dir}, append(args, code)...), &stdout, &stderr); exit != 0 {
+			t.Fatal(exit, stderr.String())
+		}
+		for name, content := range files {
+			if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || !bytes.Equal(b, content) {
+				t.Fatal(name, err, len(b))
+			}
+			if !strings.Contains(stdout.String(), name+" received") {
+				t.Fatal(stdout.String())
+			}
+		}
+		return dir
+	}
+
+	// Segments are received in parallel ranges.
+	code, done := startSend(t, "-server", server.URL, "-segments", "3", filepath.Join(src, "a.txt"), filepath.Join(src, "big.bin"))
+	dir := receive(code, "-segments", "3")
+	if exit := <-done; exit != 0 {
+		t.Fatal(exit)
+	}
+	if n := ranges.Load(); n != 3 {
+		t.Fatal(n)
+	}
+
+	// Files uploaded as a whole are received as a whole.
+	ranges.Store(0)
+	code, done = startSend(t, "-server", server.URL, "-segments", "1", filepath.Join(src, "a.txt"), filepath.Join(src, "big.bin"))
+	receive(code, "-segments", "3")
+	if exit := <-done; exit != 0 {
+		t.Fatal(exit)
+	}
+	if n := ranges.Load(); n != 1 {
+		t.Fatal(n)
+	}
+
+	// Existing files are not overwritten.
+	code, done = startSend(t, "-server", server.URL, filepath.Join(src, "a.txt"))
+	if exit := runReceive([]string{"-server", server.URL, "-dir", dir, code}, io.Discard, io.Discard); exit != 1 {
+		t.Fatal(exit)
+	}
+	task.Query(code).CtxCancel()
+	<-done
+}
+
+func TestReceiveText(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6; c.TaskFailDelay = 0 })
+	server := newSendServer(t)
+	defer server.Close()
+
+	var stdout strings.Builder
+	if exit := runSend([]string{"-server", server.URL, "-qr=false", "-text", "hello"}, &stdout, io.Discard); exit != 0 {
+		t.Fatal(exit)
+	}
+	code := codePattern.FindStringSubmatch(stdout.String())[1]
+	defer task.Query(code).CtxCancel()
+	stdout.Reset()
+	if exit := runReceive([]string{"-server", server.URL, code}, &stdout, io.Discard); exit != 0 || stdout.String() != "hello" {
+		t.Fatal(exit, stdout.String())
+	}
+	if exit := runReceive([]string{"-server", server.URL, "NOSUCH"}, io.Discard, io.Discard); exit != 1 {
+		t.Fatal(exit)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mkch/webfs/task"
+)
+
+// segmentWindow is the max bytes buffered by a relay of a file uploaded
+// in segments, see task.RangeReader.
+const segmentWindow = 4 << 20
+
+var (
+	// errNoRange means the Range header is absent or ignored,
+	// and the whole file is served.
+	errNoRange = errors.New("no range")
+	// errRangeNotSatisfiable means the Range header is out of the file.
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+)
+
+// parseRange parses the Range header s of a file of size bytes, and returns
+// the range [start, end) requested. Only single byte ranges are supported,
+// other ranges are ignored as RFC 9110 allows.
+func parseRange(s string, size int64) (start, end int64, err error) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, errNoRange
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size, errNoRange
+	}
+	if first == "" {
+		// The suffix range.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, errNoRange
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		return max(size-n, 0), size, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, errNoRange
+	}
+	end = size
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, size, errNoRange
+		}
+		end = min(end+1, size)
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, end, nil
+}
+
+// taskContext returns a context of ctx that's also cancelled when t is done,
+// with the error of t as the cause.
+func taskContext(ctx context.Context, t *task.Task) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-t.CtxDone():
+			cancel(t.CtxErr())
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, test := range []struct {
+		header     string
+		start, end int64
+		err        error
+	}{
+		{"", 0, 100, errNoRange},
+		{"bytes=0-", 0, 100, nil},
+		{"bytes=10-19", 10, 20, nil},
+		{"bytes=90-200", 90, 100, nil},
+		{"bytes=-10", 90, 100, nil},
+		{"bytes=-200", 0, 100, nil},
+		{"bytes=0-1,5-6", 0, 100, errNoRange},
+		{"bytes=5-1", 0, 100, errNoRange},
+		{"items=0-1", 0, 100, errNoRange},
+		{"bytes=100-", 0, 0, errRangeNotSatisfiable},
+		{"bytes=-0", 0, 0, errRangeNotSatisfiable},
+	} {
+		start, end, err := parseRange(test.header, 100)
+		if start != test.start || end != test.end || err != test.err {
+			t.Fatal(test.header, start, end, err)
+		}
+	}
+}
+
+func TestRelaySegments(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	data := make([]byte, 300000)
+	rand.Read(data)
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a.bin", Size: int64(len(data))}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+
+	// upload uploads the segment of data at offset, and returns the status code.
+	upload := func(offset, length int) int {
+		u := fmt.Sprintf("%v/send_file?task=%v&secret=secret&index=0&offset=%v", server.URL, tk.ID(), offset)
+		resp, err := http.Post(u, "application/octet-stream", bytes.NewReader(data[offset:offset+length]))
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// Ranges aligned with the segments are received concurrently.
+	var wg sync.WaitGroup
+	for offset := 0; offset < len(data); offset += 100000 {
+		wg.Add(1)
+		go func(offset int) {
+			defer wg.Done()
+			if code := upload(offset, 100000); code != http.StatusOK {
+				t.Error(offset, code)
+			}
+		}(offset)
+	}
+	for offset := 0; offset < len(data); offset += 100000 {
+		wg.Add(1)
+		go func(offset int) {
+			defer wg.Done()
+			last := offset + 100000 - 1
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/r/"+tk.ID(), nil)
+			req.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", offset, last))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil || resp.StatusCode != http.StatusPartialContent || !bytes.Equal(b, data[offset:last+1]) ||
+				resp.Header.Get("Content-Range") != fmt.Sprintf("bytes %v-%v/%v", offset, last, len(data)) {
+				t.Error(offset, err, resp.Status, len(b), resp.Header)
+			}
+		}(offset)
+	}
+	wg.Wait()
+	// The ranges make up one transfer.
+	for deadline := time.Now().Add(time.Second); tk.File(0).Status().State != task.FileDone; time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatal(tk.File(0).Status())
+		}
+	}
+	if status := tk.File(0).Status(); status.Downloads != 1 || status.ReceiverIP != "127.0.0.1" {
+		t.Fatal(status)
+	}
+}
+
+func TestSendFileSegment(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a", Size: 10}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+
+	for _, test := range []struct {
+		offset   string
+		body     string
+		encoding string
+		code     int
+	}{
+		{"x", "abc", "", http.StatusBadRequest},
+		{"-1", "abc", "", http.StatusBadRequest},
+		{"8", "abc", "", http.StatusBadRequest},
+		{"0", "", "", http.StatusBadRequest},
+		{"0", "abc", "gzip", http.StatusUnsupportedMediaType},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/send_file?task="+tk.ID()+"&secret=secret&index=0&offset="+test.offset, strings.NewReader(test.body))
+		req.Header.Set("Content-Encoding", test.encoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Fatal(test.offset, resp.Status)
+		}
+	}
+
+	// Ranges out of the file.
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/send_file?task="+tk.ID()+"&secret=secret&index=0&offset=0", strings.NewReader("0123456789"))
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-tk.File(0).SegmentParked()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/r/"+tk.ID(), nil)
+	req.Header.Set("Range", "bytes=10-")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || resp.Header.Get("Content-Range") != "bytes */10" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+}
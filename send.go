@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+const serverEnv = "WEBFS_SERVER"
+
+// sendRetryDelay is the delay before uploading a file again after a failure.
+const sendRetryDelay = time.Second
+
+// sendSegmentThreshold is the min size of files uploaded in segments.
+var sendSegmentThreshold int64 = 64 << 20
+
+// sentTask is the task created by the send sub command.
+type sentTask struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+}
+
+// sendClient is the client of the send sub command.
+type sendClient struct {
+	server string // URL of the server without trailing slash.
+	stdout io.Writer
+	stderr io.Writer
+	gzip   bool // Whether to compress compressible files when uploading.
+	// Number of segments uploaded in parallel of files of at least
+	// sendSegmentThreshold bytes. Files are uploaded as a whole if less than 2.
+	segments int
+}
+
+// runSend runs the "send" sub command.
+func runSend(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("webfs send", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", os.Getenv(serverEnv), "URL of the server, also "+serverEnv+" environment variable, discovered on the local network if empty")
+	timeout := fs.Duration("timeout", 0, "Timeout of the task, the default of the server if 0")
+	text := fs.Bool("text", false, "Send the arguments as a text instead of files, or the standard input if no argument")
+	keep := fs.Bool("keep", false, "Keep sending after every file is received, until interrupted")
+	qr := fs.Bool("qr", isTerminal(os.Stdout), "Print the QR code of the receiving URL")
+	gz := fs.Bool("gzip", false, "Compress compressible files when uploading")
+	segments := fs.Int("segments", 4, fmt.Sprintf("Number of segments uploaded in parallel of files of at least %v MiB, 1 to upload them as a whole", sendSegmentThreshold>>20))
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: webfs send [flags] file...\n       webfs send [flags] -text [text...]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if !*text && fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *server == "" {
+		var err error
+		if *server, err = defaultServer(ctx, stderr); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	c := &sendClient{strings.TrimSuffix(*server, "/"), stdout, stderr, *gz, *segments}
+	query := url.Values{}
+	if *timeout > 0 {
+		query.Set("timeout", strconv.Itoa(int((*timeout+time.Second-1)/time.Second)))
+	}
+
+	var err error
+	if *text {
+		err = c.sendText(ctx, query, strings.Join(fs.Args(), " "), *qr)
+	} else {
+		err = c.sendFiles(ctx, query, fs.Args(), *keep, *qr)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// newTask creates a task with body.
+func (c *sendClient) newTask(ctx context.Context, query url.Values, body io.Reader) (*sentTask, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.server+"/new_task?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("new task: %v: %v", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var t sentTask
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (c *sendClient) printTask(t *sentTask, qr bool) {
+	fmt.Fprintf(c.stdout, "Code: %v\nURL: %v\n", t.ID, t.URL)
+	if qr {
+		printQR(c.stdout, t.URL)
+	}
+}
+
+// cancelTask cancels t. Errors are ignored, the task expires anyway.
+func (c *sendClient) cancelTask(t *sentTask) {
+	resp, err := http.Get(c.server + "/cancel_task?task=" + url.QueryEscape(t.ID) + "&secret=" + url.QueryEscape(t.Secret))
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// sendText creates a text task. The standard input is sent if text is empty.
+func (c *sendClient) sendText(ctx context.Context, query url.Values, text string, qr bool) error {
+	var body io.Reader = strings.NewReader(text)
+	if text == "" {
+		body = os.Stdin
+	}
+	query.Set("type", "text")
+	t, err := c.newTask(ctx, query, body)
+	if err != nil {
+		return err
+	}
+	c.printTask(t, qr)
+	return nil
+}
+
+// sendFiles creates a task of files and uploads them until every file is
+// received once, or until ctx is done if keep is true.
+func (c *sendClient) sendFiles(ctx context.Context, query url.Values, names []string, keep, qr bool) error {
+	files := make([]task.FileInfo, 0, len(names))
+	for _, name := range names {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("%v is not a regular file", name)
+		}
+		files = append(files, task.FileInfo{Name: filepath.Base(name), Size: fi.Size()})
+	}
+	body, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	t, err := c.newTask(ctx, query, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	defer c.cancelTask(t)
+	c.printTask(t, qr)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		n := 1
+		if c.segments > 1 && files[i].Size >= max(sendSegmentThreshold, int64(c.segments)) {
+			n = c.segments
+		}
+		rounds := newSegmentRounds(n)
+		for seg := 0; seg < n; seg++ {
+			offset, length := segmentRange(files[i].Size, n, seg)
+			if n == 1 {
+				length = -1
+			}
+			wg.Add(1)
+			go func(i int, name string, seg int, offset, length int64) {
+				defer wg.Done()
+				for {
+					err := c.uploadFile(ctx, t, i, name, offset, length)
+					if err == nil {
+						if rounds.done(seg) {
+							fmt.Fprintf(c.stdout, "%v received\n", files[i].Name)
+						}
+						if keep {
+							continue
+						}
+						return
+					}
+					if ctx.Err() != nil {
+						return
+					}
+					var retry *retryError
+					if !errors.As(err, &retry) {
+						cancel(err)
+						return
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(retry.after):
+					}
+				}
+			}(i, name, seg, offset, length)
+		}
+	}
+	wg.Wait()
+	if err := context.Cause(ctx); err != nil && err != context.Canceled {
+		return err
+	}
+	return nil
+}
+
+// segmentRange returns the offset and the length of segment seg of a file
+// of size bytes divided into n segments.
+func segmentRange(size int64, n, seg int) (offset, length int64) {
+	offset = size * int64(seg) / int64(n)
+	return offset, size*int64(seg+1)/int64(n) - offset
+}
+
+// segmentRounds counts the rounds every segment of a file is received.
+type segmentRounds struct {
+	l      sync.Mutex
+	counts []int // Times received of every segment.
+	rounds int   // Times the whole file is received.
+}
+
+func newSegmentRounds(n int) *segmentRounds {
+	return &segmentRounds{counts: make([]int, n)}
+}
+
+// done marks segment seg received, and reports whether the whole file
+// is received once more.
+func (r *segmentRounds) done(seg int) bool {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.counts[seg]++
+	for _, count := range r.counts {
+		if count <= r.rounds {
+			return false
+		}
+	}
+	r.rounds++
+	return true
+}
+
+// retryError is an upload failure that can be retried after a delay.
+type retryError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+// uploadFile uploads the file at index of t and waits for a receiver.
+// The segment of length bytes at offset is uploaded if length is not
+// negative. A nil error means the file or the segment is received.
+func (c *sendClient) uploadFile(ctx context.Context, t *sentTask, index int, name string, offset, length int64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var body io.Reader = f
+	size := fi.Size()
+	if length >= 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		body, size = io.LimitReader(f, length), length
+	}
+	compress := false
+	// Segments are not compressed, the server reassembles them as is.
+	if c.gzip && length < 0 && fi.Size() >= gzipMinSize {
+		br := bufio.NewReaderSize(f, sniffLen)
+		head, _ := br.Peek(sniffLen)
+		compress = compressible(fi.Name(), http.DetectContentType(head))
+		body = br
+	}
+	if compress {
+		pr, pw := io.Pipe()
+		go func(src io.Reader) {
+			zw, _ := gzip.NewWriterLevel(pw, gzip.BestSpeed)
+			_, err := io.Copy(zw, src)
+			if err == nil {
+				err = zw.Close()
+			}
+			pw.CloseWithError(err)
+		}(body)
+		// Fail the compression if the request ends early.
+		defer pr.Close()
+		body = pr
+	}
+	u := fmt.Sprintf("%v/send_file?task=%v&secret=%v&index=%v", c.server, url.QueryEscape(t.ID), url.QueryEscape(t.Secret), index)
+	if length >= 0 {
+		u += "&offset=" + strconv.FormatInt(offset, 10)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return err
+	}
+	if compress {
+		// The server passes the gzip stream through to receivers accepting it.
+		req.Header.Set("Content-Encoding", "gzip")
+	} else {
+		req.ContentLength = size
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &retryError{err, sendRetryDelay}
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return errors.New("task cancelled")
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		after, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &retryError{errors.New(resp.Status), max(time.Duration(after)*time.Second, sendRetryDelay)}
+	default:
+		// The receiver has gone, wait for another one.
+		return &retryError{fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(msg))), sendRetryDelay}
+	}
+}
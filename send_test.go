@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+func newSendServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new_task", handleNewTask)
+	mux.HandleFunc("/cancel_task", handleCancelTask)
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	return httptest.NewServer(mux)
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	l   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.buf.String()
+}
+
+var codePattern = regexp.MustCompile(`Code: (\w+)`)
+
+func TestSend(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
+	server := newSendServer(t)
+	defer server.Close()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(name, []byte("content of a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	done := make(chan int)
+	go func() { done <- runSend([]string{"-server", server.URL, "-qr=false", name}, stdout, stderr) }()
+
+	var code string
+	for deadline := time.Now().Add(time.Second * 5); code == ""; time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatal(stdout, stderr)
+		}
+		if m := codePattern.FindStringSubmatch(stdout.String()); m != nil {
+			code = m[1]
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/r/" + code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil || string(b) != "content of a" {
+		t.Fatal(err, string(b))
+	}
+	if exit := <-done; exit != 0 {
+		t.Fatal(exit, stderr)
+	}
+	if !strings.Contains(stdout.String(), "a.txt received") {
+		t.Fatal(stdout)
+	}
+	// The task is cancelled after all files are received.
+	for deadline := time.Now().Add(time.Second); task.Query(code) != nil; time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatal("task is not cancelled")
+		}
+	}
+}
+
+func TestSendGzip(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
+	server := newSendServer(t)
+	defer server.Close()
+
+	content := []byte(strings.Repeat("a,b,c\n", 1000))
+	name := filepath.Join(t.TempDir(), "a.csv")
+	if err := os.WriteFile(name, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	done := make(chan int)
+	go func() { done <- runSend([]string{"-server", server.URL, "-qr=false", "-gzip", name}, stdout, stderr) }()
+
+	var code string
+	for deadline := time.Now().Add(time.Second * 5); code == ""; time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatal(stdout, stderr)
+		}
+		if m := codePattern.FindStringSubmatch(stdout.String()); m != nil {
+			code = m[1]
+		}
+	}
+	// The compressed upload is passed through.
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/r/"+code, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := (&http.Client{Transport: &http.Transport{DisableCompression: true}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(zr); err != nil || !bytes.Equal(b, content) {
+		t.Fatal(err, len(b))
+	}
+	if exit := <-done; exit != 0 {
+		t.Fatal(exit, stderr)
+	}
+}
+
+func TestSendText(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
+	server := newSendServer(t)
+	defer server.Close()
+
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	if exit := runSend([]string{"-server", server.URL, "-qr=false", "-text", "hello", "world"}, stdout, stderr); exit != 0 {
+		t.Fatal(exit, stderr)
+	}
+	m := codePattern.FindStringSubmatch(stdout.String())
+	if m == nil {
+		t.Fatal(stdout)
+	}
+	defer task.Query(m[1]).CtxCancel()
+	if text, _ := task.Query(m[1]).Text(); text != "hello world" {
+		t.Fatal(text)
+	}
+}
+
+func TestSendUsage(t *testing.T) {
+	if exit := runSend(nil, io.Discard, io.Discard); exit != 2 {
+		t.Fatal(exit)
+	}
+	if exit := runSend([]string{"-server", "http://127.0.0.1:1", "/no/such/file"}, io.Discard, io.Discard); exit != 1 {
+		t.Fatal(exit)
+	}
+}
+
+func TestSendSegments(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
+	old := sendSegmentThreshold
+	sendSegmentThreshold = 1000
+	defer func() { sendSegmentThreshold = old }()
+	server := newSendServer(t)
+	defer server.Close()
+
+	content := make([]byte, 100000)
+	rand.Read(content)
+	name := filepath.Join(t.TempDir(), "a.bin")
+	if err := os.WriteFile(name, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	done := make(chan int)
+	go func() {
+		done <- runSend([]string{"-server", server.URL, "-qr=false", "-segments", "3", name}, stdout, stderr)
+	}()
+
+	var code string
+	for deadline := time.Now().Add(time.Second * 5); code == ""; time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatal(stdout, stderr)
+		}
+		if m := codePattern.FindStringSubmatch(stdout.String()); m != nil {
+			code = m[1]
+		}
+	}
+	resp, err := http.Get(server.URL + "/r/" + code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, err := io.ReadAll(resp.Body); err != nil || !bytes.Equal(b, content) {
+		t.Fatal(err, len(b))
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatal(resp.Header)
+	}
+	if exit := <-done; exit != 0 {
+		t.Fatal(exit, stderr)
+	}
+	if n := strings.Count(stdout.String(), "a.bin received"); n != 1 {
+		t.Fatal(stdout)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mkch/webfs/task"
+	"github.com/mkch/webfs/websocket"
+)
+
+// WebSocket transport of senders.
+//
+// Instead of a request of /send_file for every file, a sender can upload all
+// files of a task through a WebSocket at /send_ws?task=<id>&secret=<secret>.
+// Browsers limit the connections to a server, so tasks of many files would
+// stall otherwise.
+//
+// Messages in text frames are JSON objects with a "type". The sender offers
+// files, the server requests a stream of a file when a receiver is ready:
+//
+//	Sender:  {"type":"offer","indexes":[0,1]}               Files to send.
+//	Server:  {"type":"request","stream":1,"index":0,"window":N} Send file 0 as stream 1.
+//	Sender:  binary frames of the 4 byte big endian stream ID followed by data.
+//	Server:  {"type":"credit","stream":1,"bytes":N}         N more bytes can be sent.
+//	Sender:  {"type":"end","stream":1}                      All bytes are sent.
+//	Server:  {"type":"done","stream":1,"index":0}           The file is received.
+//	Server:  {"type":"cancel","stream":1,"index":0}         The receiver has gone.
+//	Sender:  {"type":"abort","stream":1}                    The file can't be read.
+//
+// At most window bytes not yet credited are sent in a stream. A file is
+// offered again after every transfer, until the connection is closed. The
+// connection is closed with code wsCloseTaskDone when the task is done.
+
+const (
+	// wsWindow is the max bytes of a stream buffered by the server.
+	wsWindow = 1 << 20
+	// wsCreditThreshold is the bytes consumed before credited to the sender.
+	wsCreditThreshold = wsWindow / 4
+	// wsMaxMessage is the max size of a message from the sender.
+	wsMaxMessage = 256 * 1024
+	// wsCloseTaskDone is the close code when the task is cancelled or expired.
+	wsCloseTaskDone = 4404
+)
+
+// wsPingInterval is the interval of pings keeping the connection alive.
+// The connection fails if nothing is received for twice of it.
+var wsPingInterval = time.Second * 30
+
+// wsMessage is a text message of the WebSocket transport.
+type wsMessage struct {
+	Type    string `json:"type"`
+	Stream  uint32 `json:"stream,omitempty"`
+	Index   int    `json:"index"`
+	Indexes []int  `json:"indexes,omitempty"`
+	Window  int    `json:"window,omitempty"`
+	Bytes   int    `json:"bytes,omitempty"`
+}
+
+// wsSender is the WebSocket connection of a sender.
+type wsSender struct {
+	conn     *websocket.Conn
+	t        *task.Task
+	senderIP string
+	ctx      context.Context // Done when the connection fails.
+	wg       sync.WaitGroup  // Offering goroutines.
+
+	l          sync.Mutex
+	streams    map[uint32]*wsStream
+	offered    map[int]bool
+	lastStream uint32
+}
+
+// wsStream is the content of a file streamed by the sender.
+// It is read by a receiver.
+type wsStream struct {
+	s     *wsSender
+	id    uint32
+	index int
+	ready chan struct{} // Signaled when chunks are pushed or the stream ends.
+
+	l         sync.Mutex
+	requested bool
+	chunks    [][]byte
+	buffered  int // Bytes in chunks.
+	consumed  int // Bytes read but not credited.
+	ended     bool
+	err       error // Error of the sender after ended.
+}
+
+// send sends msg to the sender.
+func (s *wsSender) send(msg *wsMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+func (s *wsSender) newStream(index int) *wsStream {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.lastStream++
+	st := &wsStream{s: s, id: s.lastStream, index: index, ready: make(chan struct{}, 1)}
+	s.streams[st.id] = st
+	return st
+}
+
+func (s *wsSender) stream(id uint32) *wsStream {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.streams[id]
+}
+
+func (s *wsSender) removeStream(st *wsStream) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.streams, st.id)
+}
+
+// signal wakes up the reader of st.
+func (st *wsStream) signal() {
+	select {
+	case st.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push adds data sent by the sender to st.
+func (st *wsStream) push(data []byte) error {
+	st.l.Lock()
+	defer st.l.Unlock()
+	if st.ended {
+		return errors.New("data after the end of stream")
+	}
+	if st.buffered+len(data) > wsWindow {
+		return errors.New("stream window exceeded")
+	}
+	st.chunks = append(st.chunks, data)
+	st.buffered += len(data)
+	st.signal()
+	return nil
+}
+
+// end marks st is ended by the sender with err, nil if all bytes are sent.
+func (st *wsStream) end(err error) {
+	st.l.Lock()
+	defer st.l.Unlock()
+	st.ended = true
+	st.err = err
+	st.signal()
+}
+
+// Read requests the file from the sender on the first call, and reads
+// the data sent by the sender.
+func (st *wsStream) Read(p []byte) (int, error) {
+	st.l.Lock()
+	requested := st.requested
+	st.requested = true
+	st.l.Unlock()
+	if !requested {
+		if err := st.s.send(&wsMessage{Type: "request", Stream: st.id, Index: st.index, Window: wsWindow}); err != nil {
+			return 0, err
+		}
+	}
+	for {
+		st.l.Lock()
+		if len(st.chunks) > 0 {
+			n := copy(p, st.chunks[0])
+			if st.chunks[0] = st.chunks[0][n:]; len(st.chunks[0]) == 0 {
+				st.chunks = st.chunks[1:]
+			}
+			st.buffered -= n
+			st.consumed += n
+			var credit int
+			if st.consumed >= wsCreditThreshold {
+				credit, st.consumed = st.consumed, 0
+			}
+			st.l.Unlock()
+			if credit > 0 {
+				// A failure of the connection is returned by the next Read.
+				st.s.send(&wsMessage{Type: "credit", Stream: st.id, Bytes: credit})
+			}
+			return n, nil
+		}
+		if st.ended {
+			err := st.err
+			st.l.Unlock()
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		st.l.Unlock()
+		select {
+		case <-st.ready:
+		case <-st.s.ctx.Done():
+			return 0, context.Cause(st.s.ctx)
+		}
+	}
+}
+
+// offer offers file index to receivers until the connection fails or
+// the task is done.
+func (s *wsSender) offer(index int) {
+	defer s.wg.Done()
+	file := s.t.File(index)
+	for {
+		st := s.newStream(index)
+		content := task.NewFileContent(task.NewSizeReader(st, file.Info().Size))
+		parkedUploads.Inc()
+		file.Park(s.senderIP)
+		var err error
+		select {
+		case <-s.t.CtxDone():
+			err = s.t.CtxErr()
+		case <-s.ctx.Done():
+			err = context.Cause(s.ctx)
+		case file.Content() <- content:
+		}
+		parkedUploads.Dec()
+		if err != nil {
+			file.Unpark()
+			s.removeStream(st)
+			return
+		}
+
+		// The receiver always finishes the download, or fails reading
+		// the stream after the connection fails.
+		<-content.DownloadDone()
+		err = content.DownloadErr()
+		s.removeStream(st)
+		if s.ctx.Err() != nil {
+			return
+		}
+		msg := &wsMessage{Type: "done", Stream: st.id, Index: index}
+		if err != nil {
+			s.t.Logger().Debug("websocket upload failed", "index", index, "stream", st.id, "error", err)
+			msg.Type = "cancel"
+		}
+		s.send(msg)
+	}
+}
+
+// readLoop handles the messages of the sender until the connection fails.
+func (s *wsSender) readLoop() error {
+	for {
+		typ, p, err := s.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if typ == websocket.BinaryMessage {
+			if len(p) < 4 {
+				return s.violation("short data frame")
+			}
+			// Streams cancelled by the server may still receive data in flight.
+			if st := s.stream(binary.BigEndian.Uint32(p)); st != nil {
+				if err := st.push(p[4:]); err != nil {
+					return s.violation(err.Error())
+				}
+			}
+			continue
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(p, &msg); err != nil {
+			return s.violation("invalid message")
+		}
+		switch msg.Type {
+		case "offer":
+			for _, index := range msg.Indexes {
+				if index < 0 || index >= s.t.NFiles() {
+					return s.violation("invalid file index")
+				}
+				s.l.Lock()
+				offered := s.offered[index]
+				s.offered[index] = true
+				s.l.Unlock()
+				if !offered {
+					s.wg.Add(1)
+					go s.offer(index)
+				}
+			}
+		case "end", "abort":
+			if st := s.stream(msg.Stream); st != nil {
+				var err error
+				if msg.Type == "abort" {
+					err = errors.New("aborted by the sender")
+				}
+				st.end(err)
+			}
+		default:
+			return s.violation("unknown message type")
+		}
+	}
+}
+
+// violation closes the connection for a protocol violation.
+func (s *wsSender) violation(reason string) error {
+	s.conn.WriteClose(websocket.ClosePolicyViolation, reason)
+	return errors.New(reason)
+}
+
+// wsConnSet is a set of WebSocket connections.
+type wsConnSet struct {
+	l     sync.Mutex
+	conns map[*websocket.Conn]struct{}
+}
+
+// wsConns is the connections of WebSocket senders. They are hijacked from
+// the server, so http.Server.Shutdown doesn't close them.
+var wsConns = &wsConnSet{conns: make(map[*websocket.Conn]struct{})}
+
+func (s *wsConnSet) add(conn *websocket.Conn) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.conns[conn] = struct{}{}
+}
+
+func (s *wsConnSet) remove(conn *websocket.Conn) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.conns, conn)
+}
+
+// closeAll closes all the connections, and returns the number of them.
+func (s *wsConnSet) closeAll() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return len(s.conns)
+}
+
+// handleSendWS uploads the files of a task through a WebSocket.
+func handleSendWS(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	t := task.Query(query.Get("task"))
+	if t == nil || t.Secret() != query.Get("secret") {
+		failedLookups.Inc()
+		// Increase the cost of brute force.
+		time.Sleep(time.Duration(getConfig().TaskFailDelay))
+		httpError(w, r, http.StatusNotFound, "error.no_such_task")
+		return
+	}
+	if _, isText := t.Text(); isText {
+		httpError(w, r, http.StatusBadRequest, "error.text_task")
+		return
+	}
+	// A connection takes a slot however many files it offers.
+	if !parkedSlots.acquire(getConfig().MaxParkedUploads) {
+		requestLogger(r).Warn("too many parked uploads", "task", t.ID())
+		rejectLimited(w, r, http.StatusServiceUnavailable, "error.too_many_uploads")
+		return
+	}
+	defer parkedSlots.release()
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		requestLogger(r).Debug("websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+	wsConns.add(conn)
+	defer wsConns.remove(conn)
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetIdleTimeout(wsPingInterval * 2)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	s := &wsSender{
+		conn:     conn,
+		t:        t,
+		senderIP: clientIP(r),
+		ctx:      ctx,
+		streams:  make(map[uint32]*wsStream),
+		offered:  make(map[int]bool),
+	}
+	logger := requestLogger(r).With("task", t.ID())
+	logger.Debug("websocket sender connected")
+
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.CtxDone():
+				conn.WriteClose(wsCloseTaskDone, printer(r).T(taskErrID(t.CtxErr())))
+				// Fail the reading if the sender doesn't close,
+				// right away on shutdown to stop the relays of the streams.
+				delay := time.Second * 5
+				if errors.Is(t.CtxErr(), errShuttingDown) {
+					delay = 0
+				}
+				time.AfterFunc(delay, func() { conn.Close() })
+				return
+			case <-ticker.C:
+				if conn.WriteMessage(websocket.PingMessage, nil) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	err = s.readLoop()
+	cancel(err)
+	s.wg.Wait()
+	logger.Debug("websocket sender disconnected", "error", err)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+	"github.com/mkch/webfs/websocket"
+)
+
+// wsClient is a sender of the WebSocket transport in tests.
+type wsClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialSendWS(t *testing.T, server *httptest.Server, tk *task.Task) *wsClient {
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/send_ws?task=" + tk.ID() + "&secret=" + tk.Secret()
+	conn, err := websocket.Dial(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &wsClient{t, conn}
+}
+
+func (c *wsClient) send(msg any) {
+	b, _ := json.Marshal(msg)
+	if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) sendData(stream uint32, data []byte) {
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, append(binary.BigEndian.AppendUint32(nil, stream), data...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) read() *wsMessage {
+	typ, p, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var msg wsMessage
+	if typ != websocket.TextMessage || json.Unmarshal(p, &msg) != nil {
+		c.t.Fatal(typ, string(p))
+	}
+	return &msg
+}
+
+func TestSendWS(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send_ws", handleSendWS)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	big := bytes.Repeat([]byte("0123456789"), wsWindow/5) // Twice the window.
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{
+		{Name: "a.txt", Size: 5}, {Name: "b.bin", Size: int64(len(big))},
+	}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+
+	c := dialSendWS(t, server, tk)
+	c.send(map[string]any{"type": "offer", "indexes": []int{0, 1, 1}})
+	for tk.File(0).Status().State != task.FileParked || tk.File(1).Status().State != task.FileParked {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	type result struct {
+		body []byte
+		err  error
+	}
+	receive := func(index string) <-chan result {
+		ch := make(chan result, 1)
+		go func() {
+			resp, err := http.Get(server.URL + "/r/" + tk.ID() + "?index=" + index)
+			if err != nil {
+				ch <- result{nil, err}
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			ch <- result{body, err}
+		}()
+		return ch
+	}
+
+	// Small file.
+	received := receive("0")
+	req := c.read()
+	if req.Type != "request" || req.Index != 0 || req.Window != wsWindow {
+		t.Fatal(req)
+	}
+	c.sendData(req.Stream, []byte("hello"))
+	c.send(map[string]any{"type": "end", "stream": req.Stream})
+	if r := <-received; r.err != nil || string(r.body) != "hello" {
+		t.Fatal(r.err, string(r.body))
+	}
+	if msg := c.read(); msg.Type != "done" || msg.Stream != req.Stream || msg.Index != 0 {
+		t.Fatal(msg)
+	}
+
+	// The file larger than the window is sent after credits.
+	received = receive("1")
+	req = c.read()
+	if req.Type != "request" || req.Index != 1 {
+		t.Fatal(req)
+	}
+	sent, credit := 0, req.Window
+	for sent < len(big) {
+		for credit > 0 && sent < len(big) {
+			n := min(64*1024, credit, len(big)-sent)
+			c.sendData(req.Stream, big[sent:sent+n])
+			sent += n
+			credit -= n
+		}
+		if sent < len(big) {
+			msg := c.read()
+			if msg.Type != "credit" || msg.Stream != req.Stream {
+				t.Fatal(msg)
+			}
+			credit += msg.Bytes
+		}
+	}
+	c.send(map[string]any{"type": "end", "stream": req.Stream})
+	if r := <-received; r.err != nil || !bytes.Equal(r.body, big) {
+		t.Fatal(r.err, len(r.body))
+	}
+	for {
+		msg := c.read()
+		if msg.Type == "credit" {
+			continue
+		}
+		if msg.Type != "done" || msg.Index != 1 {
+			t.Fatal(msg)
+		}
+		break
+	}
+	if tk.File(1).Status().Downloads != 1 {
+		t.Fatal(tk.File(1).Status())
+	}
+
+	// Aborted by the sender, the file is offered again.
+	// The content is read directly, HTTP clients retry aborted requests.
+	readErr := make(chan error, 1)
+	go func() {
+		content := <-tk.File(0).Content()
+		content.SetDownloadStarted()
+		_, err := io.ReadAll(content.Reader())
+		content.SetDownloadDone(err)
+		readErr <- err
+	}()
+	req = c.read()
+	c.send(map[string]any{"type": "abort", "stream": req.Stream})
+	if err := <-readErr; err == nil {
+		t.Fatal("no error")
+	}
+	if msg := c.read(); msg.Type != "cancel" || msg.Index != 0 {
+		t.Fatal(msg)
+	}
+
+	// The connection is closed when the task is cancelled.
+	tk.CtxCancel()
+	var closeErr *websocket.CloseError
+	if _, _, err := c.conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != wsCloseTaskDone {
+		t.Fatal(err)
+	}
+}
+
+func TestSendWSErrors(t *testing.T) {
+	setConfig(t, func(c *config) { c.TaskFailDelay = 0 })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send_ws", handleSendWS)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a", Size: 1}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+
+	// Tasks are checked before the handshake.
+	resp, err := http.Get(server.URL + "/send_ws?task=" + tk.ID() + "&secret=wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatal(resp.Status)
+	}
+
+	for _, msg := range []string{`{"type":"offer","indexes":[1]}`, `{"type":"unknown"}`, `not json`} {
+		c := dialSendWS(t, server, tk)
+		if err := c.conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		var closeErr *websocket.CloseError
+		if _, _, err := c.conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+			t.Fatal(msg, err)
+		}
+	}
+
+	// Data beyond the window.
+	c := dialSendWS(t, server, tk)
+	c.send(map[string]any{"type": "offer", "indexes": []int{0}})
+	for tk.File(0).Status().State != task.FileParked {
+		time.Sleep(time.Millisecond * 10)
+	}
+	violated := make(chan struct{})
+	go func() {
+		content := <-tk.File(0).Content()
+		content.SetDownloadStarted()
+		// Request the stream without consuming it.
+		content.Reader().Read(make([]byte, 1))
+		<-violated
+		_, err := io.ReadAll(content.Reader())
+		content.SetDownloadDone(err)
+	}()
+	req := c.read()
+	for i := 0; i <= wsWindow/(wsMaxMessage/2); i++ {
+		c.sendData(req.Stream, make([]byte, wsMaxMessage/2))
+	}
+	var closeErr *websocket.CloseError
+	if _, _, err := c.conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+		t.Fatal(err)
+	}
+	close(violated)
+}
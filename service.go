@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/mkch/webfs/systemd"
+	"github.com/mkch/webfs/task"
+)
+
+// adminSocketName is the name of the socket of the admin area passed by
+// systemd socket activation, set by FileDescriptorName= of the socket unit.
+const adminSocketName = "admin"
+
+// listen returns the listeners of the HTTP service and the admin area.
+// The listeners passed by systemd socket activation are used if any,
+// otherwise c.HTTP and c.AdminHTTP are listened.
+// admin is nil if the admin area is served by the HTTP service.
+func listen(c *config) (listeners []net.Listener, admin net.Listener, err error) {
+	inherited, err := systemd.Listeners()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(inherited) > 0 {
+		return splitInherited(c, inherited)
+	}
+
+	l, err := net.Listen("tcp", c.HTTP)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.AdminHTTP != "" {
+		if admin, err = net.Listen("tcp", c.AdminHTTP); err != nil {
+			l.Close()
+			return nil, nil, err
+		}
+	}
+	return []net.Listener{l}, admin, nil
+}
+
+// splitInherited returns the listeners of the HTTP service and the admin
+// area in the listeners passed by systemd. The socket named adminSocketName
+// is required if c.AdminHTTP is set, so that the admin area is never
+// exposed on the HTTP service by a missing socket. All the listeners are
+// closed if an error is returned.
+func splitInherited(c *config, inherited []systemd.Listener) (listeners []net.Listener, admin net.Listener, err error) {
+	for _, l := range inherited {
+		if l.Name == adminSocketName && admin == nil {
+			admin = l
+		} else {
+			listeners = append(listeners, l)
+		}
+	}
+	switch {
+	case len(listeners) == 0:
+		err = errors.New("no HTTP listener passed by systemd")
+	case admin == nil && c.AdminHTTP != "":
+		err = fmt.Errorf("admin_http is set but no socket named %q is passed by systemd", adminSocketName)
+	}
+	if err != nil {
+		for _, l := range inherited {
+			l.Close()
+		}
+		return nil, nil, err
+	}
+	slog.Info("using listeners passed by systemd", "http", len(listeners), "admin", admin != nil)
+	return listeners, admin, nil
+}
+
+// notify sends state to systemd if the service is started by it.
+func notify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		slog.Warn("failed to notify systemd", "state", state, "error", err)
+	}
+}
+
+// watchdog sends keep-alive pings to systemd until ctx is done,
+// if the watchdog of the service is enabled.
+func watchdog(ctx context.Context) {
+	interval, err := systemd.WatchdogInterval()
+	if err != nil {
+		slog.Warn("systemd watchdog disabled", "error", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			notify("WATCHDOG=1")
+		}
+	}
+}
+
+// idlePollInterval is the interval to check whether the server is idle.
+var idlePollInterval = time.Second
+
+// watchIdle calls stop when there has been no task for idle_timeout,
+// and returns when ctx is done or stop is called.
+// The server never goes idle if idle_timeout is 0.
+func watchIdle(ctx context.Context, stop func()) {
+	ticker := time.NewTicker(idlePollInterval)
+	defer ticker.Stop()
+	idleSince := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if task.Count() > 0 {
+				idleSince = now
+				continue
+			}
+			if timeout := time.Duration(getConfig().IdleTimeout); timeout > 0 && now.Sub(idleSince) >= timeout {
+				slog.Info("idle timeout", "idle_timeout", timeout)
+				stop()
+				return
+			}
+		}
+	}
+}
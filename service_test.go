@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/systemd"
+	"github.com/mkch/webfs/task"
+)
+
+func TestWatchIdle(t *testing.T) {
+	defer func(d time.Duration) { idlePollInterval = d }(idlePollInterval)
+	idlePollInterval = time.Millisecond * 10
+	setConfig(t, func(c *config) { c.IdleTimeout = duration(time.Millisecond * 100) })
+	// Remove the tasks left by other tests.
+	task.CancelAll(context.Canceled)
+
+	tk, err := task.New(nil, 6, time.Millisecond*200, "secret", nil, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	watchIdle(ctx, stop)
+	// Idle timeout starts after the task expires,
+	// as seen by the last poll before it.
+	if d := time.Since(start); d < time.Millisecond*300-idlePollInterval || d > time.Second {
+		t.Fatal(d)
+	}
+	if tk.CtxErr() != context.DeadlineExceeded {
+		t.Fatal(tk.CtxErr())
+	}
+	if ctx.Err() == nil {
+		t.Fatal("not stopped")
+	}
+}
+
+func TestWatchIdleDisabled(t *testing.T) {
+	defer func(d time.Duration) { idlePollInterval = d }(idlePollInterval)
+	idlePollInterval = time.Millisecond * 10
+	setConfig(t, func(c *config) { c.IdleTimeout = 0 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	watchIdle(ctx, func() { t.Fatal("stopped") })
+}
+
+func TestListen(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	c := defaultConfig()
+	c.HTTP = "127.0.0.1:0"
+	c.AdminHTTP = "127.0.0.1:0"
+	listeners, admin, err := listen(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	for _, l := range listeners {
+		defer l.Close()
+	}
+	if len(listeners) != 1 || admin == nil {
+		t.Fatal(listeners, admin)
+	}
+
+	c.AdminHTTP = ""
+	listeners, admin, err = listen(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners[0].Close()
+	if admin != nil {
+		t.Fatal(admin)
+	}
+}
+
+func TestSplitInherited(t *testing.T) {
+	// newListener returns a listener passed by systemd named name.
+	newListener := func(name string) systemd.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return systemd.Listener{Listener: l, Name: name}
+	}
+	c := defaultConfig()
+	c.AdminHTTP = "127.0.0.1:0"
+	listeners, admin, err := splitInherited(&c, []systemd.Listener{newListener("http"), newListener(adminSocketName)})
+	if err != nil || len(listeners) != 1 || admin == nil {
+		t.Fatal(listeners, admin, err)
+	}
+
+	// The admin area is not served by the HTTP service.
+	l := newListener("http")
+	if _, _, err := splitInherited(&c, []systemd.Listener{l}); err == nil {
+		t.Fatal("no error")
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("not closed")
+	}
+	c.AdminHTTP = ""
+	if listeners, admin, err = splitInherited(&c, []systemd.Listener{newListener("")}); err != nil || len(listeners) != 1 || admin != nil {
+		t.Fatal(listeners, admin, err)
+	}
+	if _, _, err := splitInherited(&c, []systemd.Listener{newListener(adminSocketName)}); err == nil {
+		t.Fatal("no error")
+	}
+}
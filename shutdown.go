@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+// draining is true after the server starts to shut down.
+// No new task is accepted while draining.
+var draining atomic.Bool
+
+// errShuttingDown is the cause of tasks cancelled by shutdown.
+var errShuttingDown = errors.New("server is shutting down")
+
+// drainPollInterval is the interval to check active transfers while draining.
+const drainPollInterval = time.Millisecond * 100
+
+// shutdownGrace is the time given to handlers to respond
+// after their tasks are cancelled.
+var shutdownGrace = time.Second * 5
+
+// shutdown gracefully shuts down srv.
+// It stops accepting new tasks, waits for active transfers to finish up to
+// drainTimeout, cancels all remaining tasks and then shuts down srv,
+// giving handlers shutdownGrace to respond before closing it.
+// So shutting down takes up to drainTimeout plus shutdownGrace.
+func shutdown(srv *http.Server, drainTimeout time.Duration) {
+	start := time.Now()
+	draining.Store(true)
+	activeAtStart := activeDownloads.Value()
+	slog.Info("shutting down", "active_transfers", activeAtStart, "drain_timeout", drainTimeout, "grace", shutdownGrace)
+
+	deadline := time.NewTimer(drainTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+wait:
+	for activeDownloads.Value() > 0 {
+		select {
+		case <-deadline.C:
+			break wait
+		case <-ticker.C:
+		}
+	}
+	aborted := activeDownloads.Value()
+
+	cancelled := task.CancelAll(errShuttingDown)
+
+	// Handlers of the cancelled tasks respond the error in the grace time,
+	// the remaining transfers are cut after it.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("shutdown timeout", "error", err)
+		srv.Close()
+	}
+	// The WebSocket senders have been told by their tasks.
+	if n := wsConns.closeAll(); n > 0 {
+		slog.Info("closed websocket connections", "count", n)
+	}
+
+	slog.Info("shutdown complete",
+		"active_transfers", activeAtStart,
+		"aborted_transfers", aborted,
+		"cancelled_tasks", cancelled,
+		"duration", time.Since(start))
+}
+
+// cutOnShutdown cuts the connection of w when task t is cancelled by
+// shutdown, by setting its deadlines, so that a transfer in progress stops
+// instead of holding the shutdown for shutdownGrace.
+// The returned func stops watching, and must be called before the handler
+// of w returns.
+func cutOnShutdown(w http.ResponseWriter, t *task.Task) (stop func()) {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-t.CtxDone():
+			if errors.Is(t.CtxErr(), errShuttingDown) {
+				rc := http.NewResponseController(w)
+				rc.SetReadDeadline(time.Now())
+				rc.SetWriteDeadline(time.Now())
+			}
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+func TestShutdown(t *testing.T) {
+	defer draining.Store(false)
+
+	setConfig(t, func(c *config) { c.CodeLen = 6 })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new_task", handleNewTask)
+	mux.HandleFunc("/send_file", handleSendFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Post(fmt.Sprintf("%v/new_task", server.URL), "application/json",
+		strings.NewReader(`[{"name":"file1","size":3}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var task struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		t.Fatal(err)
+	}
+
+	type sendResult struct {
+		Code int
+		Body string
+		Err  error
+	}
+	sendChan := make(chan *sendResult)
+	go func() {
+		resp, err := http.Post(fmt.Sprintf("%v/send_file?task=%v&secret=%v&index=0",
+			server.URL, url.QueryEscape(task.ID), url.QueryEscape(task.Secret)),
+			"", strings.NewReader("abc"))
+		if err != nil {
+			sendChan <- &sendResult{Err: err}
+			return
+		}
+		body, err := io.ReadAll(resp.Body)
+		sendChan <- &sendResult{resp.StatusCode, string(body), err}
+	}()
+
+	// Wait for the upload to be parked.
+	for parkedUploads.Value() == 0 {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	shutdown(server.Config, time.Second)
+
+	result := <-sendChan
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if result.Code != http.StatusBadRequest || !strings.Contains(result.Body, errShuttingDown.Error()) {
+		t.Fatal(result.Code, result.Body)
+	}
+
+	w := httptest.NewRecorder()
+	handleNewTask(w, httptest.NewRequest("POST", "/new_task", strings.NewReader(`[{"name":"file1","size":3}]`)))
+	if code := w.Result().StatusCode; code != http.StatusServiceUnavailable {
+		t.Fatal(code)
+	}
+}
+
+func TestShutdownAborted(t *testing.T) {
+	defer draining.Store(false)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/send_file", handleSendFile)
+	mux.HandleFunc("/send_ws", handleSendWS)
+	mux.HandleFunc("/r/", handleReceiveFile)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newTask := func() *task.Task {
+		tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a", Size: 3}}, "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(tk.CtxCancel)
+		return tk
+	}
+	send := func(tk *task.Task, body io.Reader) (int, string) {
+		resp, err := http.Post(server.URL+"/send_file?task="+tk.ID()+"&secret=secret&index=0", "", body)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	// A transfer never finishing.
+	active := newTask()
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go send(active, pr)
+	pw.Write([]byte("a"))
+	go func() {
+		if resp, err := http.Get(server.URL + "/r/" + active.ID()); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	// A parked upload.
+	parked := newTask()
+	result := make(chan string, 1)
+	go func() {
+		code, body := send(parked, strings.NewReader("abc"))
+		result <- fmt.Sprint(code, body)
+	}()
+	// A WebSocket sender.
+	ws := dialSendWS(t, server, newTask())
+	for activeDownloads.Value() == 0 || parkedUploads.Value() == 0 {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// The active transfer is cut without waiting for shutdownGrace.
+	start := time.Now()
+	shutdown(server.Config, time.Millisecond*100)
+	if d := time.Since(start); d > shutdownGrace/2 {
+		t.Fatal(d)
+	}
+	// The parked sender gets the error before the transfer is cut.
+	if r := <-result; !strings.HasPrefix(r, fmt.Sprint(http.StatusBadRequest)) || !strings.Contains(r, errShuttingDown.Error()) {
+		t.Fatal(r)
+	}
+	// The WebSocket connection is closed.
+	for {
+		if _, _, err := ws.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
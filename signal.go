@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mkch/webfs/task"
+	"github.com/mkch/webfs/token"
+)
+
+// WebRTC signaling.
+//
+// Receivers exchange SDP and ICE candidates with the sender page through
+// the task, so that files can be transferred peer to peer. The messages are
+// opaque JSON values to the server. A receiver opens a session with the task
+// code, the sender answers with the secret:
+//
+//	POST /signal?task=<id>                            Open a session.
+//	POST /signal?task=<id>&session=<s>                Receiver to sender.
+//	GET  /signal?task=<id>&session=<s>                Poll messages to the receiver.
+//	POST /signal?task=<id>&secret=<secret>&session=<s> Sender to receiver.
+//	GET  /signal?task=<id>&secret=<secret>            Poll messages to the sender.
+//
+// Polls wait up to signalPollTimeout for messages and respond a JSON array.
+// Sessions are opened only while the sender is polling, others fall back
+// to the relay.
+
+// signalPollTimeout is the max time a poll waits for messages.
+var signalPollTimeout = time.Second * 25
+
+const (
+	// maxSignalSize is the max size of a signaling message.
+	maxSignalSize = 64 * 1024
+	// maxSignalQueue is the max number of messages waiting to be polled.
+	maxSignalQueue = 64
+	// maxSignalSessions is the max number of sessions of a task.
+	maxSignalSessions = 32
+	// signalSessionIdle is the time after which a session not polled is removed.
+	signalSessionIdle = time.Minute
+	// signalSenderGrace is the time the sender is considered online after a poll.
+	signalSenderGrace = time.Second * 5
+	signalSessionLen  = 12
+)
+
+// signalMessage is a message to the sender.
+type signalMessage struct {
+	Session string          `json:"session"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type signalSession struct {
+	messages chan json.RawMessage // To the receiver.
+	lastPoll time.Time
+}
+
+// signalBox is the signaling state of a task.
+type signalBox struct {
+	toSender chan signalMessage
+
+	l              sync.Mutex
+	senderPolls    int       // Number of active polls of the sender.
+	senderLastSeen time.Time // End of the last poll of the sender.
+	sessions       map[string]*signalSession
+}
+
+var signalBoxes = struct {
+	l sync.Mutex
+	m map[*task.Task]*signalBox
+}{m: make(map[*task.Task]*signalBox)}
+
+// getSignalBox returns the signaling state of t, creating it if create is true.
+// It returns nil if not found.
+func getSignalBox(t *task.Task, create bool) *signalBox {
+	signalBoxes.l.Lock()
+	defer signalBoxes.l.Unlock()
+	box := signalBoxes.m[t]
+	if box == nil && create {
+		box = &signalBox{toSender: make(chan signalMessage, maxSignalQueue), sessions: make(map[string]*signalSession)}
+		signalBoxes.m[t] = box
+		go func() {
+			<-t.CtxDone()
+			signalBoxes.l.Lock()
+			delete(signalBoxes.m, t)
+			signalBoxes.l.Unlock()
+		}()
+	}
+	return box
+}
+
+// p2pAvailable reports whether the sender of t accepts peer to peer transfers.
+func p2pAvailable(t *task.Task) bool {
+	if !getConfig().P2P {
+		return false
+	}
+	box := getSignalBox(t, false)
+	if box == nil {
+		return false
+	}
+	box.l.Lock()
+	defer box.l.Unlock()
+	return box.senderPolls > 0 || time.Since(box.senderLastSeen) < signalSenderGrace
+}
+
+// openSession opens a new session, or returns "" if there are too many.
+func (box *signalBox) openSession() string {
+	box.l.Lock()
+	defer box.l.Unlock()
+	for id, s := range box.sessions {
+		if time.Since(s.lastPoll) > signalSessionIdle {
+			delete(box.sessions, id)
+		}
+	}
+	if len(box.sessions) >= maxSignalSessions {
+		return ""
+	}
+	id := token.New(signalSessionLen)
+	box.sessions[id] = &signalSession{messages: make(chan json.RawMessage, maxSignalQueue), lastPoll: time.Now()}
+	return id
+}
+
+func (box *signalBox) session(id string) *signalSession {
+	box.l.Lock()
+	defer box.l.Unlock()
+	s := box.sessions[id]
+	if s != nil {
+		s.lastPoll = time.Now()
+	}
+	return s
+}
+
+func (box *signalBox) senderPoll(start bool) {
+	box.l.Lock()
+	defer box.l.Unlock()
+	if start {
+		box.senderPolls++
+	} else {
+		box.senderPolls--
+	}
+	box.senderLastSeen = time.Now()
+}
+
+// pollSignals waits for messages in ch and returns all of them, or an empty
+// slice if no message arrives before the timeout.
+func pollSignals[T any](r *http.Request, t *task.Task, ch chan T) []T {
+	messages := []T{}
+	timer := time.NewTimer(signalPollTimeout)
+	defer timer.Stop()
+	select {
+	case m := <-ch:
+		messages = append(messages, m)
+	case <-timer.C:
+		return messages
+	case <-t.CtxDone():
+		return messages
+	case <-r.Context().Done():
+		return messages
+	}
+	for {
+		select {
+		case m := <-ch:
+			messages = append(messages, m)
+		default:
+			return messages
+		}
+	}
+}
+
+// readSignal reads a message from the body of r.
+// If the message is invalid, an error is responded and ok is false.
+func readSignal(w http.ResponseWriter, r *http.Request) (msg json.RawMessage, ok bool) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignalSize))
+	if err != nil || !json.Valid(b) {
+		httpError(w, r, http.StatusBadRequest, "error.invalid_body")
+		return nil, false
+	}
+	return b, true
+}
+
+// handleSignal relays the signaling messages of WebRTC.
+func handleSignal(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	t := task.Query(query.Get("task"))
+	isSender := query.Has("secret")
+	if t == nil || (isSender && t.Secret() != query.Get("secret")) {
+		failedLookups.Inc()
+		// Increase the cost of brute force.
+		time.Sleep(time.Duration(getConfig().TaskFailDelay))
+		httpError(w, r, http.StatusNotFound, "error.no_such_task")
+		return
+	}
+	if _, isText := t.Text(); isText || !getConfig().P2P {
+		httpError(w, r, http.StatusNotFound, "error.p2p_unavailable")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	sessionID := query.Get("session")
+	if isSender {
+		box := getSignalBox(t, true)
+		if r.Method == http.MethodGet {
+			box.senderPoll(true)
+			messages := pollSignals(r, t, box.toSender)
+			box.senderPoll(false)
+			writeJSON(w, r, messages)
+			return
+		}
+		s := box.session(sessionID)
+		if s == nil {
+			httpError(w, r, http.StatusNotFound, "error.p2p_unavailable")
+			return
+		}
+		msg, ok := readSignal(w, r)
+		if !ok {
+			return
+		}
+		select {
+		case s.messages <- msg:
+		default:
+			httpError(w, r, http.StatusServiceUnavailable, "error.p2p_unavailable")
+		}
+		return
+	}
+
+	box := getSignalBox(t, false)
+	if box == nil {
+		httpError(w, r, http.StatusNotFound, "error.p2p_unavailable")
+		return
+	}
+	if sessionID == "" {
+		if r.Method != http.MethodPost || !p2pAvailable(t) {
+			httpError(w, r, http.StatusNotFound, "error.p2p_unavailable")
+			return
+		}
+		id := box.openSession()
+		if id == "" {
+			httpError(w, r, http.StatusServiceUnavailable, "error.p2p_unavailable")
+			return
+		}
+		p2pSessions.Inc()
+		t.Logger().Debug("p2p session opened", "session", id, "client_ip", clientIP(r))
+		writeJSON(w, r, struct {
+			Session    string      `json:"session"`
+			ICEServers []iceServer `json:"ice_servers"`
+		}{id, iceServers()})
+		return
+	}
+	s := box.session(sessionID)
+	if s == nil {
+		httpError(w, r, http.StatusNotFound, "error.p2p_unavailable")
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, r, pollSignals(r, t, s.messages))
+		return
+	}
+	msg, ok := readSignal(w, r)
+	if !ok {
+		return
+	}
+	select {
+	case box.toSender <- signalMessage{sessionID, msg}:
+	default:
+		httpError(w, r, http.StatusServiceUnavailable, "error.p2p_unavailable")
+	}
+}
+
+// iceServer is an RTCIceServer of the browser.
+type iceServer struct {
+	URLs       string `json:"urls"`
+	Username   string `json:"username,omitempty"`
+	Credential string `json:"credential,omitempty"`
+}
+
+// iceServers returns the configured ICE servers, never nil.
+// The credentials of a TURN server are given as in turn:user:pass@host:port.
+func iceServers() []iceServer {
+	servers := []iceServer{}
+	for _, u := range getConfig().ICEServers {
+		scheme, rest, _ := strings.Cut(u, ":")
+		var server iceServer
+		if userinfo, host, ok := strings.Cut(rest, "@"); ok {
+			server.Username, server.Credential, _ = strings.Cut(userinfo, ":")
+			rest = host
+		}
+		server.URLs = scheme + ":" + rest
+		servers = append(servers, server)
+	}
+	return servers
+}
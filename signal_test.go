@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+// doSignal performs a signaling request and returns the response.
+func doSignal(method, query, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handleSignal(w, httptest.NewRequest(method, "/signal?"+query, strings.NewReader(body)))
+	return w
+}
+
+func TestSignal(t *testing.T) {
+	setConfig(t, func(c *config) {
+		c.TaskFailDelay = 0
+		c.ICEServers = stringList{"stun:stun.example.com", "turn:user:pass@turn.example.com:3478"}
+	})
+	defer func(d time.Duration) { signalPollTimeout = d }(signalPollTimeout)
+	signalPollTimeout = time.Millisecond * 100
+
+	tk, err := task.New(nil, 6, time.Minute, "secret", []task.FileInfo{{Name: "a", Size: 1}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+	id := "task=" + tk.ID()
+	sender := id + "&secret=secret"
+
+	// No session before the sender polls.
+	if w := doSignal("POST", id, ""); w.Code != http.StatusNotFound || p2pAvailable(tk) {
+		t.Fatal(w.Code)
+	}
+	if w := doSignal("GET", id+"&secret=wrong", ""); w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+	if w := doSignal("GET", sender, ""); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatal(w.Code, w.Body)
+	}
+	if !p2pAvailable(tk) {
+		t.Fatal("sender is not polling")
+	}
+
+	w := doSignal("POST", id, "")
+	var opened struct {
+		Session    string      `json:"session"`
+		ICEServers []iceServer `json:"ice_servers"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&opened); err != nil || opened.Session == "" {
+		t.Fatal(w.Code, err)
+	}
+	if len(opened.ICEServers) != 2 || opened.ICEServers[0] != (iceServer{URLs: "stun:stun.example.com"}) ||
+		opened.ICEServers[1] != (iceServer{URLs: "turn:turn.example.com:3478", Username: "user", Credential: "pass"}) {
+		t.Fatal(opened.ICEServers)
+	}
+	session := "&session=" + opened.Session
+
+	// Receiver to sender.
+	if w := doSignal("POST", id+session, "not json"); w.Code != http.StatusBadRequest {
+		t.Fatal(w.Code)
+	}
+	for _, msg := range []string{`{"type":"offer"}`, `{"type":"candidate"}`} {
+		if w := doSignal("POST", id+session, msg); w.Code != http.StatusOK {
+			t.Fatal(w.Code, w.Body)
+		}
+	}
+	w = doSignal("GET", sender, "")
+	if body := strings.TrimSpace(w.Body.String()); body != `[{"session":"`+opened.Session+`","data":{"type":"offer"}},`+
+		`{"session":"`+opened.Session+`","data":{"type":"candidate"}}]` {
+		t.Fatal(body)
+	}
+
+	// Sender to receiver.
+	if w := doSignal("POST", sender+"&session=unknown", "{}"); w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+	if w := doSignal("POST", sender+session, `{"type":"answer"}`); w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Body)
+	}
+	w = doSignal("GET", id+session, "")
+	if body := strings.TrimSpace(w.Body.String()); body != `[{"type":"answer"}]` {
+		t.Fatal(body)
+	}
+
+	// Disabled.
+	setConfig(t, func(c *config) { c.P2P = false })
+	if w := doSignal("GET", sender, ""); w.Code != http.StatusNotFound || p2pAvailable(tk) {
+		t.Fatal(w.Code)
+	}
+}
+
+func TestSignalText(t *testing.T) {
+	tk, err := task.NewText(nil, 6, time.Minute, "secret", "text", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+	if w := doSignal("GET", "task="+tk.ID()+"&secret=secret", ""); w.Code != http.StatusNotFound {
+		t.Fatal(w.Code)
+	}
+}
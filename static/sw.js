@@ -0,0 +1,41 @@
+// Service worker of webfs.
+//
+// Shared files and text are POSTed to the share URL by the Web Share Target
+// of the OS. They are stored in shareCache and the send page is opened to
+// send them, because a file can only be uploaded while the sender is online.
+
+const shareCache = "webfs-share";
+const shareURL = new URL("share", self.registration.scope);
+
+self.addEventListener("install", () => self.skipWaiting());
+self.addEventListener("activate", (e) => e.waitUntil(self.clients.claim()));
+
+self.addEventListener("fetch", (e) => {
+    const url = new URL(e.request.url);
+    if (e.request.method != "POST" || url.origin != shareURL.origin || url.pathname != shareURL.pathname) {
+        return;
+    }
+    e.respondWith((async () => {
+        const data = await e.request.formData();
+        await caches.delete(shareCache);
+        const cache = await caches.open(shareCache);
+        const files = data.getAll("files").filter(f => f instanceof File);
+        for (let i = 0; i < files.length; i++) {
+            await cache.put(new URL(`share/file/${i}`, self.registration.scope), new Response(files[i], {
+                headers: {
+                    "Content-Type": files[i].type || "application/octet-stream",
+                    "X-File-Name": encodeURIComponent(files[i].name),
+                }
+            }));
+        }
+        // The URL is often in the text already.
+        const url = data.get("url") || "";
+        const text = [data.get("title"), data.get("text"), url]
+            .filter((s, i, parts) => s && !(s == url && parts[1]?.includes(url)))
+            .join("\n");
+        if (files.length == 0 && text) {
+            await cache.put(new URL("share/text", self.registration.scope), new Response(text));
+        }
+        return Response.redirect(new URL("send?share", self.registration.scope), 303);
+    })());
+});
@@ -0,0 +1,102 @@
+// Package systemd implements socket activation and the sd_notify protocol
+// of systemd without cgo.
+package systemd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+var listenFdsStart = 3
+
+// Listener is a listener passed by systemd.
+type Listener struct {
+	net.Listener
+	Name string // Name of the socket in LISTEN_FDNAMES, empty if not named.
+}
+
+// Listeners returns the listeners passed by systemd socket activation,
+// or nil if the process is not socket activated.
+// The LISTEN_* environment variables are unset, so that they are not
+// inherited by child processes.
+func Listeners() ([]Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		closeOnExec(fd)
+		var name string
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// FileListener dups the file descriptor.
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, Listener{l, name})
+	}
+	return listeners, nil
+}
+
+// Notify sends state to the service manager, e.g. "READY=1".
+// It returns false if NOTIFY_SOCKET is not set.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// Abstract namespace.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout of the service, or 0 if
+// the watchdog is not enabled for this process. "WATCHDOG=1" should be
+// sent to Notify more often than the timeout, typically at half of it.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid WATCHDOG_USEC")
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
@@ -0,0 +1,127 @@
+//go:build unix
+
+package systemd
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if ok, err := Notify("READY=1"); ok || err != nil {
+		t.Fatal(ok, err)
+	}
+
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "notify"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", addr.Name)
+
+	for _, state := range []string{"READY=1", "WATCHDOG=1", "STOPPING=1"} {
+		if ok, err := Notify(state); !ok || err != nil {
+			t.Fatal(ok, err)
+		}
+		buf := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != state {
+			t.Fatal(got)
+		}
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if d, err := WatchdogInterval(); d != 0 || err != nil {
+		t.Fatal(d, err)
+	}
+	t.Setenv("WATCHDOG_USEC", "3000000")
+	if d, err := WatchdogInterval(); d != time.Second*3 || err != nil {
+		t.Fatal(d, err)
+	}
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if d, err := WatchdogInterval(); d != 0 || err != nil {
+		t.Fatal(d, err)
+	}
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("WATCHDOG_USEC", "abc")
+	if _, err := WatchdogInterval(); err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "2")
+	if listeners, err := Listeners(); listeners != nil || err != nil {
+		t.Fatal(listeners, err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pass a duplicate that is owned by Listeners from now on.
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(start int) { listenFdsStart = start }(listenFdsStart)
+	listenFdsStart = fd
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "admin")
+	listeners, err := Listeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 || listeners[0].Name != "admin" {
+		t.Fatal(listeners)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Fatal("LISTEN_FDS is not unset")
+	}
+	for _, l := range listeners {
+		defer l.Close()
+		go func(l net.Listener) {
+			conn, err := l.Accept()
+			if err == nil {
+				conn.Write([]byte(l.Addr().String()))
+				conn.Close()
+			}
+		}(l)
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != l.Addr().String() {
+			t.Fatal(string(b))
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package task
+
+import "github.com/mkch/webfs/metrics"
+
+var (
+	activeTasks    = metrics.NewGauge("webfs_tasks_active", "Number of tasks in the registry.")
+	createdTasks   = metrics.NewCounter("webfs_tasks_created_total", "Number of tasks created.")
+	expiredTasks   = metrics.NewCounter("webfs_tasks_expired_total", "Number of tasks removed for timeout.")
+	cancelledTasks = metrics.NewCounter("webfs_tasks_cancelled_total", "Number of tasks removed for cancellation.")
+	rejectedTasks  = metrics.NewCounter("webfs_tasks_rejected_total", "Number of tasks rejected for too many tasks.")
+)
@@ -0,0 +1,463 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Segmented transfers.
+//
+// A sender can upload a file as byte range segments in parallel. The
+// segments are parked by OfferSegment until a RangeReader takes them.
+// A RangeReader returns a byte range of the file in order, reading the
+// segments in the range concurrently with bounded memory. Bytes of a
+// segment out of the range are not received, and the segment is done with
+// ErrPartialSegment, so that the sender offers it again.
+//
+// The ranges of a receiver make up one transfer of the file. The transfer
+// starts with the first range being read, succeeds once every byte of the
+// file is received, and fails if a range fails with no other being read.
+
+// ErrInvalidSegment is returned by OfferSegment if the segment is out of
+// the file or the size of the file is unknown.
+var ErrInvalidSegment = errors.New("invalid segment")
+
+// ErrPartialSegment is the download error of a segment of which only a part
+// is in the range of the receiver.
+var ErrPartialSegment = errors.New("segment is partially received")
+
+// errRangeReaderClosed is the download error of the segments not yet
+// received when a RangeReader is closed.
+var errRangeReaderClosed = errors.New("receiver closed")
+
+// rangeChunkSize is the max size of a chunk read from a segment.
+const rangeChunkSize = 32 * 1024
+
+// segment is a segment offered by a sender.
+type segment struct {
+	offset  int64
+	length  int64
+	content *FileContent
+	taken   chan struct{} // Closed when taken by a RangeReader.
+}
+
+// byteRange is the range [start, end) of a file.
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) overlaps(o byteRange) bool {
+	return r.start < o.end && o.start < r.end
+}
+
+// segmentPool is the parked segments of a File.
+type segmentPool struct {
+	l        sync.Mutex
+	parked   []*segment
+	active   []byteRange   // Ranges being read by RangeReaders.
+	received []byteRange   // Ranges received in the current transfer, sorted and merged.
+	changed  chan struct{} // Closed and replaced when parked or active changes.
+}
+
+// addReceivedLocked adds rng to the received ranges, and reports whether
+// the whole file of size bytes is received.
+func (p *segmentPool) addReceivedLocked(rng byteRange, size int64) bool {
+	merged := make([]byteRange, 0, len(p.received)+1)
+	for _, r := range p.received {
+		if r.end < rng.start || rng.end < r.start {
+			merged = append(merged, r)
+			continue
+		}
+		rng = byteRange{min(r.start, rng.start), max(r.end, rng.end)}
+	}
+	merged = append(merged, rng)
+	sort.Slice(merged, func(i, j int) bool { return merged[i].start < merged[j].start })
+	p.received = merged
+	return len(merged) == 1 && merged[0] == byteRange{0, size}
+}
+
+// changedLocked returns the channel closed on the next change.
+func (p *segmentPool) changedLocked() chan struct{} {
+	if p.changed == nil {
+		p.changed = make(chan struct{})
+	}
+	return p.changed
+}
+
+func (p *segmentPool) notifyLocked() {
+	if p.changed != nil {
+		close(p.changed)
+		p.changed = nil
+	}
+}
+
+// OfferSegment parks the segment of length bytes at offset of the file
+// until a RangeReader takes it or ctx is done. The reader of content reads
+// the bytes of the segment. A nil error means the segment is taken, and
+// content is done as FileContent of a whole file.
+func (c *File) OfferSegment(ctx context.Context, offset, length int64, content *FileContent) error {
+	if c.info.Size < 0 || offset < 0 || length <= 0 || offset+length > c.info.Size {
+		return ErrInvalidSegment
+	}
+	s := &segment{offset: offset, length: length, content: content, taken: make(chan struct{})}
+	p := &c.segments
+	p.l.Lock()
+	p.parked = append(p.parked, s)
+	p.notifyLocked()
+	p.l.Unlock()
+
+	select {
+	case <-s.taken:
+		return nil
+	case <-ctx.Done():
+	}
+	p.l.Lock()
+	defer p.l.Unlock()
+	select {
+	case <-s.taken: // Taken concurrently.
+		return nil
+	default:
+	}
+	for i, parked := range p.parked {
+		if parked == s {
+			p.parked = append(p.parked[:i], p.parked[i+1:]...)
+			break
+		}
+	}
+	return context.Cause(ctx)
+}
+
+// SegmentParked returns a channel that's closed when a segment of the file
+// is parked, or a closed channel if any is parked.
+func (c *File) SegmentParked() <-chan struct{} {
+	p := &c.segments
+	p.l.Lock()
+	defer p.l.Unlock()
+	if len(p.parked) > 0 {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return p.changedLocked()
+}
+
+// takenSegment is a segment taken by a RangeReader.
+type takenSegment struct {
+	*segment
+	from, to int64 // The range of the file read from the segment.
+
+	// Guarded by RangeReader.l.
+	queue [][]byte // Chunks read but not returned.
+	ended bool     // The reading goroutine has ended.
+	err   error    // The error of the reading goroutine.
+}
+
+// partial reports whether only a part of the segment is read.
+func (s *takenSegment) partial() bool {
+	return s.from > s.offset || s.to < s.offset+s.length
+}
+
+// RangeReader reads a byte range of a File from the parked segments.
+// The ranges of the RangeReaders of a File being read don't overlap, a
+// RangeReader waits until the overlapping ones are closed.
+type RangeReader struct {
+	f          *File
+	ctx        context.Context
+	receiverIP string
+	rng        byteRange
+	window     int64 // Max bytes buffered.
+
+	// Used by Read only.
+	pos        int64 // Position of the next byte returned.
+	registered bool  // Whether rng is in the active ranges.
+	ended      bool  // Whether the whole range is read.
+
+	l       sync.Mutex
+	taken   []*takenSegment // Not yet finished, sorted by from.
+	head    *takenSegment   // The segment containing pos, not limited by window.
+	used    int64           // Bytes buffered in the queues of taken.
+	closed  bool
+	changed chan struct{} // Closed and replaced when the fields above or queues change.
+}
+
+// NewRangeReader returns a RangeReader of the bytes [start, end) of the
+// file for receiverIP. At most about window bytes are buffered. Reading
+// fails when ctx is done. The RangeReader must be closed after use.
+func (c *File) NewRangeReader(ctx context.Context, receiverIP string, start, end int64, window int) *RangeReader {
+	return &RangeReader{f: c, ctx: ctx, receiverIP: receiverIP, rng: byteRange{start, end}, window: int64(window), pos: start}
+}
+
+func (r *RangeReader) changedLocked() chan struct{} {
+	if r.changed == nil {
+		r.changed = make(chan struct{})
+	}
+	return r.changed
+}
+
+func (r *RangeReader) notifyLocked() {
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+}
+
+// register waits until no active range overlaps the range of r, and adds it.
+func (r *RangeReader) register() error {
+	p := &r.f.segments
+	for {
+		p.l.Lock()
+		overlapped := false
+		for _, rng := range p.active {
+			overlapped = overlapped || rng.overlaps(r.rng)
+		}
+		if !overlapped {
+			if len(p.active) == 0 {
+				// The first range of a transfer.
+				r.f.StartTransfer(r.receiverIP)
+			}
+			p.active = append(p.active, r.rng)
+			p.notifyLocked()
+			p.l.Unlock()
+			r.registered = true
+			return nil
+		}
+		changed := p.changedLocked()
+		p.l.Unlock()
+		select {
+		case <-changed:
+		case <-r.ctx.Done():
+			return context.Cause(r.ctx)
+		}
+	}
+}
+
+// unregister removes the range of r from the active ranges, and finishes
+// the transfer if the file is received, or if r failed with err and no
+// other range is being read.
+func (r *RangeReader) unregister(err error) {
+	p := &r.f.segments
+	p.l.Lock()
+	defer p.l.Unlock()
+	for i, rng := range p.active {
+		if rng == r.rng {
+			p.active = append(p.active[:i], p.active[i+1:]...)
+			break
+		}
+	}
+	p.notifyLocked()
+	if r.ended {
+		if p.addReceivedLocked(r.rng, r.f.info.Size) {
+			p.received = nil
+			r.f.FinishTransfer(nil)
+		}
+	} else if len(p.active) == 0 {
+		// The received ranges are kept for the receiver to resume.
+		r.f.FinishTransfer(err)
+	}
+}
+
+// takeParked takes the parked segments in the unread part of the range
+// that don't overlap the taken ones. The channel closed on the next change
+// of the parked segments is returned.
+func (r *RangeReader) takeParked() <-chan struct{} {
+	p := &r.f.segments
+	p.l.Lock()
+	defer p.l.Unlock()
+	r.l.Lock()
+	defer r.l.Unlock()
+	for i := 0; i < len(p.parked); i++ {
+		s := p.parked[i]
+		ts := &takenSegment{segment: s, from: max(s.offset, r.pos), to: min(s.offset+s.length, r.rng.end)}
+		if ts.from >= ts.to || r.closed {
+			continue
+		}
+		overlapped := false
+		for _, taken := range r.taken {
+			overlapped = overlapped || (byteRange{taken.from, taken.to}).overlaps(byteRange{ts.from, ts.to})
+		}
+		if overlapped {
+			continue
+		}
+		p.parked = append(p.parked[:i], p.parked[i+1:]...)
+		i--
+		close(s.taken)
+		s.content.SetDownloadStarted()
+		r.taken = append(r.taken, ts)
+		go r.fill(ts)
+	}
+	sort.Slice(r.taken, func(i, j int) bool { return r.taken[i].from < r.taken[j].from })
+	return p.changedLocked()
+}
+
+// fill reads the segment s into its queue.
+func (r *RangeReader) fill(s *takenSegment) {
+	err := r.readSegment(s)
+	r.l.Lock()
+	defer r.l.Unlock()
+	s.ended, s.err = true, err
+	r.notifyLocked()
+}
+
+func (r *RangeReader) readSegment(s *takenSegment) error {
+	src := s.content.Reader()
+	if skip := s.from - s.offset; skip > 0 {
+		if _, err := io.CopyN(io.Discard, src, skip); err != nil {
+			return err
+		}
+	}
+	for remaining := s.to - s.from; remaining > 0; {
+		n := min(rangeChunkSize, remaining)
+		if err := r.acquire(s, n); err != nil {
+			return err
+		}
+		buf := make([]byte, n)
+		m, err := src.Read(buf)
+		r.l.Lock()
+		r.used -= n - int64(m)
+		if m > 0 {
+			s.queue = append(s.queue, buf[:m])
+		}
+		r.notifyLocked()
+		r.l.Unlock()
+		remaining -= int64(m)
+		if err == io.EOF && remaining > 0 {
+			return io.ErrUnexpectedEOF
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if s.to == s.offset+s.length {
+		// The sender must have no more data.
+		var b [1]byte
+		if n, err := io.ReadFull(src, b[:]); n > 0 {
+			return ErrFileTooLarge
+		} else if err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// acquire waits until n bytes of s can be buffered.
+// The head segment can always buffer a chunk, so that reading never stalls.
+func (r *RangeReader) acquire(s *takenSegment, n int64) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	for r.used+n > r.window && (r.head != s || len(s.queue) > 0) {
+		if r.closed {
+			return errRangeReaderClosed
+		}
+		changed := r.changedLocked()
+		r.l.Unlock()
+		<-changed
+		r.l.Lock()
+	}
+	if r.closed {
+		return errRangeReaderClosed
+	}
+	r.used += n
+	return nil
+}
+
+// finishConsumedLocked marks the download of the segments before pos done.
+// The error of a segment is returned.
+func (r *RangeReader) finishConsumedLocked() error {
+	for len(r.taken) > 0 && r.taken[0].to <= r.pos && r.taken[0].ended {
+		s := r.taken[0]
+		if s.err != nil {
+			return s.err
+		}
+		r.taken = r.taken[1:]
+		var err error
+		if s.partial() {
+			err = ErrPartialSegment
+		}
+		s.content.SetDownloadDone(err)
+	}
+	return nil
+}
+
+func (r *RangeReader) Read(p []byte) (int, error) {
+	if !r.registered {
+		if err := r.register(); err != nil {
+			return 0, err
+		}
+	}
+	for {
+		parkedChanged := r.takeParked()
+		r.l.Lock()
+		if err := r.finishConsumedLocked(); err != nil {
+			r.l.Unlock()
+			return 0, err
+		}
+		if r.pos >= r.rng.end && len(r.taken) == 0 {
+			r.ended = true
+			r.l.Unlock()
+			return 0, io.EOF
+		}
+		var head *takenSegment
+		for _, s := range r.taken {
+			if s.from <= r.pos && r.pos < s.to {
+				head = s
+				break
+			}
+		}
+		if head != r.head {
+			r.head = head
+			r.notifyLocked()
+		}
+		if head != nil && len(head.queue) > 0 {
+			n := copy(p, head.queue[0])
+			if head.queue[0] = head.queue[0][n:]; len(head.queue[0]) == 0 {
+				head.queue = head.queue[1:]
+			}
+			r.used -= int64(n)
+			r.pos += int64(n)
+			r.notifyLocked()
+			r.l.Unlock()
+			return n, nil
+		}
+		if head != nil && head.ended && head.err != nil {
+			r.l.Unlock()
+			return 0, head.err
+		}
+		changed := r.changedLocked()
+		r.l.Unlock()
+		select {
+		case <-changed:
+		case <-parkedChanged:
+		case <-r.ctx.Done():
+			return 0, context.Cause(r.ctx)
+		}
+	}
+}
+
+// Close marks the download of the segments not yet received failed,
+// and stops reading them.
+func (r *RangeReader) Close() error {
+	r.l.Lock()
+	if r.closed {
+		r.l.Unlock()
+		return nil
+	}
+	r.closed = true
+	taken := r.taken
+	r.taken = nil
+	r.notifyLocked()
+	r.l.Unlock()
+
+	err := errRangeReaderClosed
+	if r.ctx.Err() != nil {
+		err = context.Cause(r.ctx)
+	}
+	for _, s := range taken {
+		s.content.SetDownloadDone(err)
+	}
+	if r.registered {
+		r.unregister(err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package task_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/mkch/webfs/task"
+)
+
+// offerSegments offers data as n segments of file in parallel.
+// The download errors of the segments are sent to the returned channel.
+func offerSegments(t *testing.T, file *task.File, data []byte, n int) <-chan error {
+	errs := make(chan error, n)
+	length := (len(data) + n - 1) / n
+	for offset := 0; offset < len(data); offset += length {
+		segment := data[offset:min(offset+length, len(data))]
+		content := task.NewFileContent(task.NewSizeReader(bytes.NewReader(segment), int64(len(segment))))
+		go func(offset int) {
+			if err := file.OfferSegment(context.Background(), int64(offset), int64(len(segment)), content); err != nil {
+				errs <- err
+				return
+			}
+			<-content.DownloadDone()
+			errs <- content.DownloadErr()
+		}(offset)
+	}
+	return errs
+}
+
+func TestRangeReader(t *testing.T) {
+	data := make([]byte, 1000000)
+	rand.Read(data)
+	tk, err := task.New(nil, 6, time.Minute, "", []task.FileInfo{{Name: "a", Size: int64(len(data))}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+	file := tk.File(0)
+
+	if err := file.OfferSegment(context.Background(), int64(len(data))-1, 2, task.NewFileContent(nil)); err != task.ErrInvalidSegment {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := file.OfferSegment(ctx, 0, 1, task.NewFileContent(nil)); err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-file.SegmentParked():
+		t.Fatal("parked")
+	default:
+	}
+	errs := offerSegments(t, file, data, 4)
+	<-file.SegmentParked()
+
+	// A window smaller than the segments.
+	r := file.NewRangeReader(context.Background(), "127.0.0.2", 0, int64(len(data)), 64*1024)
+	got, err := io.ReadAll(r)
+	if status := file.Status(); status.State != task.FileTransferring || status.ReceiverIP != "127.0.0.2" {
+		t.Fatal(status)
+	}
+	r.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatal(err, len(got))
+	}
+	if status := file.Status(); status.State != task.FileDone || status.Downloads != 1 {
+		t.Fatal(status)
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The segments out of the range are partially received.
+	errs = offerSegments(t, file, data, 4)
+	r = file.NewRangeReader(context.Background(), "", 100, 300000, 64*1024)
+	got, err = io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, data[100:300000]) {
+		t.Fatal(err, len(got))
+	}
+	// The transfer goes on until the whole file is received.
+	if status := file.Status(); status.State != task.FileTransferring || status.Downloads != 1 {
+		t.Fatal(status)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, task.ErrPartialSegment) {
+			t.Fatal(err)
+		}
+	}
+
+	// Reading fails when ctx is done.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r = file.NewRangeReader(ctx, "", 0, int64(len(data)), 64*1024)
+	_, err = io.ReadAll(r)
+	r.Close()
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if status := file.Status(); status.State != task.FileFailed || status.Downloads != 1 {
+		t.Fatal(status)
+	}
+	// The taken segments are failed.
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != context.DeadlineExceeded {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRangeReaderSize(t *testing.T) {
+	tk, err := task.New(nil, 6, time.Minute, "", []task.FileInfo{{Name: "a", Size: 10}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.CtxCancel()
+	file := tk.File(0)
+
+	// The sender has less data than the segment.
+	content := task.NewFileContent(task.NewSizeReader(bytes.NewReader([]byte("abc")), 10))
+	go file.OfferSegment(context.Background(), 0, 10, content)
+	r := file.NewRangeReader(context.Background(), "", 0, 10, 1024)
+	_, err = io.ReadAll(r)
+	r.Close()
+	if err != task.ErrFileTooSmall {
+		t.Fatal(err)
+	}
+	<-content.DownloadDone()
+	if content.DownloadErr() == nil {
+		t.Fatal("no error")
+	}
+}
@@ -0,0 +1,84 @@
+package task
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrFileTooLarge is returned by the reader of NewSizeReader if the
+// underlying reader has more data than the declared size.
+var ErrFileTooLarge = errors.New("file is larger than the declared size")
+
+// ErrFileTooSmall is returned by the reader of NewSizeReader if the
+// underlying reader reaches EOF before the declared size.
+var ErrFileTooSmall = errors.New("file is smaller than the declared size")
+
+// sizeReader reads exactly size bytes from r.
+type sizeReader struct {
+	r         io.Reader
+	remaining int64 // Bytes to read before EOF.
+	err       error // Sticky error.
+}
+
+// NewSizeReader returns a reader that reads from r and enforces
+// that r has exactly size bytes.
+// No more than size bytes are returned. If r has more data, ErrFileTooLarge
+// is returned in place of the last bytes read, so that a receiver never gets
+// the whole declared size of a file larger than declared; if r has less,
+// ErrFileTooSmall is returned in place of io.EOF.
+// If size is negative, r is returned unchanged.
+func NewSizeReader(r io.Reader, size int64) io.Reader {
+	if size < 0 {
+		return r
+	}
+	return &sizeReader{r: r, remaining: size}
+}
+
+func (r *sizeReader) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.remaining == 0 {
+		// Empty files.
+		r.err = r.probe()
+		return 0, r.err
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err = r.r.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		err = ErrFileTooSmall
+	}
+	if err != nil && err != io.EOF {
+		r.err = err
+		return
+	}
+	if r.remaining == 0 {
+		// Withhold the last bytes until r is known to end here.
+		if err == nil {
+			if err = r.probe(); err != io.EOF {
+				r.err = err
+				return 0, err
+			}
+		}
+		r.err = io.EOF
+	}
+	return
+}
+
+// probe reads an extra byte after the declared size. It returns io.EOF
+// if r.r is at EOF, ErrFileTooLarge if r.r has more data,
+// or the error reading r.r.
+func (r *sizeReader) probe() error {
+	var b [1]byte
+	for {
+		n, err := r.r.Read(b[:])
+		if n > 0 {
+			return ErrFileTooLarge
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -3,9 +3,12 @@ package task
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mkch/webfs/token"
@@ -17,7 +20,8 @@ type FileInfo struct {
 }
 
 type FileContent struct {
-	reader io.Reader // File data.
+	reader   io.Reader // File data.
+	encoding string    // Content coding of reader, "" if none.
 
 	downloadStarted chan struct{} // Closed when downloading started.
 	downloadDone    chan struct{} // Closed when downloading done.
@@ -35,10 +39,24 @@ func NewFileContent(reader io.Reader) *FileContent {
 	}
 }
 
+// NewEncodedFileContent creates a new FileContent of which the reader is
+// encoded with a content coding like "gzip".
+func NewEncodedFileContent(reader io.Reader, encoding string) *FileContent {
+	c := NewFileContent(reader)
+	c.encoding = encoding
+	return c
+}
+
 func (c *FileContent) Reader() io.Reader {
 	return c.reader
 }
 
+// Encoding returns the content coding of the reader, "" if the reader
+// reads the file as is.
+func (c *FileContent) Encoding() string {
+	return c.encoding
+}
+
 // DownloadDone returns a channel that's closed by calling SetDownloadDone.
 func (c *FileContent) DownloadDone() <-chan struct{} {
 	return c.downloadDone
@@ -71,10 +89,98 @@ func (c *FileContent) SetDownloadStarted() {
 	close(c.downloadStarted)
 }
 
+// FileState is the transfer state of a File.
+type FileState int
+
+const (
+	FileWaiting      FileState = iota // No sender is uploading the file.
+	FileParked                        // A sender is waiting for a receiver.
+	FileTransferring                  // The file is being relayed to a receiver.
+	FileDone                          // The last transfer succeeded.
+	FileFailed                        // The last transfer failed.
+)
+
+var fileStateNames = [...]string{"waiting", "parked", "transferring", "done", "failed"}
+
+func (s FileState) String() string {
+	return fileStateNames[s]
+}
+
+func (s FileState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *FileState) UnmarshalText(text []byte) error {
+	for i, name := range fileStateNames {
+		if name == string(text) {
+			*s = FileState(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid file state %q", text)
+}
+
+// FileStatus is a snapshot of the transfer status of a File.
+type FileStatus struct {
+	State      FileState `json:"state"`
+	SenderIP   string    `json:"sender_ip,omitempty"`   // IP of the last sender.
+	ReceiverIP string    `json:"receiver_ip,omitempty"` // IP of the last receiver.
+	Downloads  int       `json:"downloads"`             // Number of succeeded transfers.
+}
+
 // File is the content of a file task.
 type File struct {
-	info    FileInfo
-	content chan (*FileContent)
+	info     FileInfo
+	content  chan (*FileContent)
+	segments segmentPool
+
+	l      sync.RWMutex
+	status FileStatus
+}
+
+// Status returns the transfer status of the file.
+func (c *File) Status() FileStatus {
+	c.l.RLock()
+	defer c.l.RUnlock()
+	return c.status
+}
+
+// Park marks the file is uploaded by senderIP and waiting for a receiver.
+func (c *File) Park(senderIP string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.status.State = FileParked
+	c.status.SenderIP = senderIP
+}
+
+// Unpark marks the sender has gone without a transfer.
+func (c *File) Unpark() {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.status.State == FileParked {
+		c.status.State = FileWaiting
+	}
+}
+
+// StartTransfer marks the file is being relayed to receiverIP.
+func (c *File) StartTransfer(receiverIP string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.status.State = FileTransferring
+	c.status.ReceiverIP = receiverIP
+}
+
+// FinishTransfer marks the transfer is done.
+// err is the error occurred during the transfer, nil if none.
+func (c *File) FinishTransfer(err error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if err != nil {
+		c.status.State = FileFailed
+	} else {
+		c.status.State = FileDone
+		c.status.Downloads++
+	}
 }
 
 func (c *File) Content() chan (*FileContent) {
@@ -96,14 +202,28 @@ func newFiles(info []FileInfo) (files []*File) {
 }
 
 type Task struct {
-	id     string
-	secret string // Secret to cancel task.
+	id       string
+	secret   string // Secret to cancel task.
+	created  time.Time
+	clientIP string // IP of the client created the task.
+
+	ctxDone        func() <-chan struct{} // The Done method of task context.
+	ctxErr         func() error           // The cause of task context.
+	ctxCancel      func()                 // The cancel function of task context.
+	ctxCancelCause func(error)            // Cancels the task context with a cause.
+
+	l        sync.Mutex
+	deadline time.Time   // Time when the task expires.
+	timer    *time.Timer // Cancels the task when deadline exceeded.
+	files    []*File
 
-	ctxDone   func() <-chan struct{} // The Done method of task context.
-	ctxErr    func() error           // The Err method of task context.
-	ctxCancel func()                 // The cancel function of task context.
+	rateLimit atomic.Int64 // Max relay rate of the task in bytes per second, 0 if unlimited.
 
-	files []*File
+	isText        bool         // Whether the task is a text task.
+	text          string       // Content of the text task.
+	textDownloads atomic.Int64 // Number of times the text is received.
+
+	logger *slog.Logger // Logger with task ID.
 }
 
 // All pending tasks indexed by ID.
@@ -126,60 +246,246 @@ func (t *Task) CtxCancel() {
 	t.ctxCancel()
 }
 
+// CtxCancelCause cancels the task with cause.
+// CtxErr returns cause after the cancellation.
+func (t *Task) CtxCancelCause(cause error) {
+	t.ctxCancelCause(cause)
+}
+
+// CtxErr returns nil if the task is not done, otherwise the cause of
+// the task context: context.DeadlineExceeded if timeout, context.Canceled
+// if cancelled by CtxCancel, or the cause passed to CtxCancelCause.
 func (t *Task) CtxErr() error {
 	return t.ctxErr()
 }
 
+// Created returns the creation time of the task.
+func (t *Task) Created() time.Time {
+	return t.created
+}
+
+// ClientIP returns the IP of the client created the task.
+func (t *Task) ClientIP() string {
+	return t.clientIP
+}
+
+// Deadline returns the time when the task expires.
+func (t *Task) Deadline() time.Time {
+	t.l.Lock()
+	defer t.l.Unlock()
+	return t.deadline
+}
+
+// ErrExtendTooLong is returned by Extend if the task would expire too late.
+var ErrExtendTooLong = errors.New("task would expire too late")
+
+// Extend extends the deadline of the task by d, if the task still expires
+// in no more than max after it. 0 max means no limit.
+// The new deadline is returned.
+func (t *Task) Extend(d, max time.Duration) (time.Time, error) {
+	t.l.Lock()
+	defer t.l.Unlock()
+	select {
+	case <-t.CtxDone():
+		return time.Time{}, t.CtxErr()
+	default:
+	}
+	if max > 0 && time.Until(t.deadline.Add(d)) > max {
+		return time.Time{}, ErrExtendTooLong
+	}
+	if !t.timer.Stop() {
+		// The timer has fired, the task is being cancelled.
+		return time.Time{}, context.DeadlineExceeded
+	}
+	t.deadline = t.deadline.Add(d)
+	t.timer.Reset(time.Until(t.deadline))
+	t.logger.Info("extended task", "deadline", t.deadline)
+	return t.deadline, nil
+}
+
+// RateLimit returns the max relay rate of the task in bytes per second.
+// 0 means unlimited.
+func (t *Task) RateLimit() int64 {
+	return t.rateLimit.Load()
+}
+
+// SetRateLimit sets the max relay rate of the task in bytes per second.
+func (t *Task) SetRateLimit(rate int64) {
+	t.rateLimit.Store(rate)
+}
+
+// Logger returns the logger of the task.
+// Records of the logger have the task ID and the attributes of the logger passed to New.
+func (t *Task) Logger() *slog.Logger {
+	return t.logger
+}
+
 func (t *Task) NFiles() int {
+	t.l.Lock()
+	defer t.l.Unlock()
 	return len(t.files)
 }
 
 func (t *Task) File(n int) *File {
+	t.l.Lock()
+	defer t.l.Unlock()
 	return t.files[n]
 }
 
-const maxTask = 10240
+// ErrTextTask is returned when adding files to a text task.
+var ErrTextTask = errors.New("text task has no files")
+
+// AddFiles appends files to the task.
+// The index of the first added file is returned.
+func (t *Task) AddFiles(files []FileInfo) (int, error) {
+	if t.isText {
+		return 0, ErrTextTask
+	}
+	t.l.Lock()
+	defer t.l.Unlock()
+	select {
+	case <-t.CtxDone():
+		return 0, t.CtxErr()
+	default:
+	}
+	first := len(t.files)
+	t.files = append(t.files, newFiles(files)...)
+	t.logger.Info("added files", "files", len(files), "total", len(t.files))
+	return first, nil
+}
+
+// Text returns the content of a text task.
+// ok is false if t is a file task.
+func (t *Task) Text() (text string, ok bool) {
+	return t.text, t.isText
+}
+
+// TextDownloaded records that the text is received.
+func (t *Task) TextDownloaded() {
+	t.textDownloads.Add(1)
+}
+
+// TextDownloads returns the number of times the text is received.
+func (t *Task) TextDownloads() int {
+	return int(t.textDownloads.Load())
+}
+
+// DefaultMaxTask is the default max number of tasks.
+const DefaultMaxTask = 10240
+
+var maxTask atomic.Int64
+
+func init() {
+	maxTask.Store(DefaultMaxTask)
+}
+
+// SetMaxTask sets the max number of tasks.
+// New fails if the number of tasks reaches n.
+func SetMaxTask(n int) {
+	maxTask.Store(int64(n))
+}
+
+// idFilter accepts the IDs of new tasks, nil if all are accepted.
+// Guarded by tasksLock.
+var idFilter func(id string) bool
+
+// SetIDFilter sets the function accepting the IDs of new tasks, so that
+// only the IDs owned by this node of a cluster are used. nil accepts all.
+func SetIDFilter(accept func(id string) bool) {
+	tasksLock.Lock()
+	defer tasksLock.Unlock()
+	idFilter = accept
+}
 
 // New creates a new file task.
-func New(idLen int, timeout time.Duration, secret string, files []FileInfo) (*Task, error) {
+// logger is used to log the lifecycle of the task, slog.Default() if nil.
+// clientIP is the IP of the client creating the task.
+func New(logger *slog.Logger, idLen int, timeout time.Duration, secret string, files []FileInfo, clientIP string) (*Task, error) {
+	return newTask(logger, idLen, timeout, secret, clientIP, func(t *Task) {
+		t.files = newFiles(files)
+	}, "files", len(files))
+}
+
+// NewText creates a new text task with the content text.
+// The parameters other than text are the same as New.
+func NewText(logger *slog.Logger, idLen int, timeout time.Duration, secret string, text string, clientIP string) (*Task, error) {
+	return newTask(logger, idLen, timeout, secret, clientIP, func(t *Task) {
+		t.isText = true
+		t.text = text
+	}, "text_size", len(text))
+}
+
+// newTask creates a new task initialized by init.
+// logArgs are logged with the creation of the task.
+func newTask(logger *slog.Logger, idLen int, timeout time.Duration, secret string, clientIP string, init func(t *Task), logArgs ...any) (*Task, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
 	tasksLock.Lock()
 	defer tasksLock.Unlock()
 
-	if len(tasks) >= maxTask {
+	if max := maxTask.Load(); int64(len(tasks)) >= max {
+		rejectedTasks.Inc()
+		logger.Warn("too many tasks", "max", max)
 		return nil, errors.New("too many tasks")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	// A timer is used instead of context.WithTimeout, so that the deadline can be extended.
+	ctx, cancelCause := context.WithCancelCause(context.Background())
+	cancel := func() { cancelCause(nil) }
+	now := time.Now()
 	task := &Task{
-		secret:    secret,
-		ctxDone:   ctx.Done,
-		ctxErr:    ctx.Err,
-		ctxCancel: cancel,
-		files:     newFiles(files),
+		secret:         secret,
+		created:        now,
+		clientIP:       clientIP,
+		ctxDone:        ctx.Done,
+		ctxErr:         func() error { return context.Cause(ctx) },
+		ctxCancel:      cancel,
+		ctxCancelCause: cancelCause,
+		deadline:       now.Add(timeout),
 	}
+	init(task)
 
 	for i := 0; i < 9999; i++ {
 		id := token.New(idLen)
 		if _, ok := tasks[id]; ok {
 			continue
 		}
+		if idFilter != nil && !idFilter(id) {
+			continue
+		}
 		task.id = id
 		tasks[id] = task
 		break
 	}
 
 	if task.id == "" {
+		cancel()
+		logger.Error("can't generate a unique task ID", "id_len", idLen)
 		return nil, errors.New("can't generate a unique task ID")
 	}
+	task.logger = logger.With("task", task.id)
+	task.timer = time.AfterFunc(timeout, func() { cancelCause(context.DeadlineExceeded) })
+
+	createdTasks.Inc()
+	activeTasks.Inc()
 
 	// Remove timeout/cancelled task.
 	go func() {
 		<-task.CtxDone()
+		task.timer.Stop()
 		remove(task.ID())
-		log.Printf("Removed task [%v]", task.ID())
+		activeTasks.Dec()
+		if task.CtxErr() == context.DeadlineExceeded {
+			expiredTasks.Inc()
+		} else {
+			cancelledTasks.Inc()
+		}
+		task.logger.Info("removed task", "reason", task.CtxErr())
 	}()
 
-	log.Printf("New task: [%v]", task.ID())
+	task.logger.Info("new task", append(logArgs, "timeout", timeout)...)
 	return task, nil
 }
 
@@ -195,3 +501,33 @@ func Query(id string) *Task {
 	defer tasksLock.RUnlock()
 	return tasks[id]
 }
+
+// CancelAll cancels all tasks with cause.
+// The number of cancelled tasks is returned.
+func CancelAll(cause error) int {
+	all := All()
+	for _, t := range all {
+		t.CtxCancelCause(cause)
+	}
+	return len(all)
+}
+
+// All returns all tasks in the order of creation.
+func All() []*Task {
+	tasksLock.RLock()
+	all := make([]*Task, 0, len(tasks))
+	for _, t := range tasks {
+		all = append(all, t)
+	}
+	tasksLock.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i].created.Before(all[j].created) })
+	return all
+}
+
+// Count returns the number of tasks.
+func Count() int {
+	tasksLock.RLock()
+	defer tasksLock.RUnlock()
+	return len(tasks)
+}
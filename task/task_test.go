@@ -6,6 +6,7 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/mkch/webfs/task"
@@ -57,7 +58,7 @@ func TestContent(t *testing.T) {
 }
 
 func TestTask(t *testing.T) {
-	ft, err := task.New(3, time.Millisecond*100, "abc", []task.FileInfo{{"abc.txt", 100}})
+	ft, err := task.New(nil, 3, time.Millisecond*100, "abc", []task.FileInfo{{"abc.txt", 100}}, "127.0.0.1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -86,3 +87,188 @@ func TestTask(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSizeReader(t *testing.T) {
+	if b, err := io.ReadAll(task.NewSizeReader(strings.NewReader("abc"), 3)); err != nil {
+		t.Fatal(err)
+	} else if str := string(b); str != "abc" {
+		t.Fatal(str)
+	}
+
+	if b, err := io.ReadAll(task.NewSizeReader(iotest.DataErrReader(strings.NewReader("abc")), 3)); err != nil {
+		t.Fatal(err)
+	} else if str := string(b); str != "abc" {
+		t.Fatal(str)
+	}
+
+	// The last bytes are not returned if there are more.
+	if b, err := io.ReadAll(task.NewSizeReader(iotest.OneByteReader(strings.NewReader("abcdef")), 3)); err != task.ErrFileTooLarge {
+		t.Fatal(err)
+	} else if str := string(b); str != "ab" {
+		t.Fatal(str)
+	}
+	if _, err := io.ReadAll(task.NewSizeReader(strings.NewReader("a"), 0)); err != task.ErrFileTooLarge {
+		t.Fatal(err)
+	}
+
+	if b, err := io.ReadAll(task.NewSizeReader(strings.NewReader("ab"), 3)); err != task.ErrFileTooSmall {
+		t.Fatal(err)
+	} else if str := string(b); str != "ab" {
+		t.Fatal(str)
+	}
+
+	if b, err := io.ReadAll(task.NewSizeReader(strings.NewReader("abcdef"), -1)); err != nil {
+		t.Fatal(err)
+	} else if str := string(b); str != "abcdef" {
+		t.Fatal(str)
+	}
+}
+
+func TestCancelAll(t *testing.T) {
+	ft, err := task.New(nil, 3, time.Minute, "abc", []task.FileInfo{{"abc.txt", 100}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cause := errors.New("some cause")
+	if n := task.CancelAll(cause); n < 1 {
+		t.Fatal(n)
+	}
+	<-ft.CtxDone()
+	if err := ft.CtxErr(); err != cause {
+		t.Fatal(err)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	ft, err := task.New(nil, 3, time.Millisecond*100, "abc", []task.FileInfo{{"abc.txt", 100}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := ft.Deadline()
+	if _, err := ft.Extend(time.Minute, time.Second); err != task.ErrExtendTooLong {
+		t.Fatal(err)
+	}
+	if d, err := ft.Extend(time.Millisecond*100, time.Second); err != nil {
+		t.Fatal(err)
+	} else if d.Sub(deadline) != time.Millisecond*100 {
+		t.Fatal(d)
+	}
+	time.Sleep(time.Millisecond * 150)
+	select {
+	case <-ft.CtxDone():
+		t.Fatal("should not be done")
+	default:
+	}
+	<-ft.CtxDone()
+	if err := ft.CtxErr(); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if _, err := ft.Extend(time.Second, 0); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestFileStatus(t *testing.T) {
+	ft, err := task.New(nil, 3, time.Minute, "abc", []task.FileInfo{{"abc.txt", 100}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.CtxCancel()
+	f := ft.File(0)
+	if s := f.Status(); s.State != task.FileWaiting {
+		t.Fatal(s)
+	}
+	f.Park("192.0.2.1")
+	if s := f.Status(); s.State != task.FileParked || s.SenderIP != "192.0.2.1" {
+		t.Fatal(s)
+	}
+	f.StartTransfer("192.0.2.2")
+	f.Unpark() // No effect.
+	if s := f.Status(); s.State != task.FileTransferring || s.ReceiverIP != "192.0.2.2" {
+		t.Fatal(s)
+	}
+	f.FinishTransfer(nil)
+	if s := f.Status(); s.State != task.FileDone || s.Downloads != 1 {
+		t.Fatal(s)
+	}
+	f.FinishTransfer(errors.New("failed"))
+	if s := f.Status(); s.State != task.FileFailed || s.Downloads != 1 {
+		t.Fatal(s)
+	}
+
+	var state task.FileState
+	if err := state.UnmarshalText([]byte("parked")); err != nil || state != task.FileParked {
+		t.Fatal(state, err)
+	}
+}
+
+func TestAddFiles(t *testing.T) {
+	ft, err := task.New(nil, 3, time.Second, "abc", []task.FileInfo{{"a.txt", 1}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := ft.AddFiles([]task.FileInfo{{"b.txt", 2}, {"c.txt", 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 1 || ft.NFiles() != 3 {
+		t.Fatal(first, ft.NFiles())
+	}
+	if info := ft.File(2).Info(); info.Name != "c.txt" || info.Size != 3 {
+		t.Fatal(info)
+	}
+	ft.CtxCancel()
+	if _, err := ft.AddFiles([]task.FileInfo{{"d.txt", 4}}); err != context.Canceled {
+		t.Fatal(err)
+	}
+}
+
+func TestTextTask(t *testing.T) {
+	tt, err := task.NewText(nil, 3, time.Second, "abc", "hello", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tt.CtxCancel()
+	if text, ok := tt.Text(); !ok || text != "hello" {
+		t.Fatal(text, ok)
+	}
+	if tt.NFiles() != 0 {
+		t.Fatal(tt.NFiles())
+	}
+	if _, err := tt.AddFiles([]task.FileInfo{{"a.txt", 1}}); err != task.ErrTextTask {
+		t.Fatal(err)
+	}
+	tt.TextDownloaded()
+	if n := tt.TextDownloads(); n != 1 {
+		t.Fatal(n)
+	}
+
+	ft, err := task.New(nil, 3, time.Second, "abc", []task.FileInfo{{"a.txt", 1}}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.CtxCancel()
+	if _, ok := ft.Text(); ok {
+		t.Fatal("file task has text")
+	}
+}
+
+func TestIDFilter(t *testing.T) {
+	task.SetIDFilter(func(id string) bool { return id[0] == 'A' })
+	defer task.SetIDFilter(nil)
+	for i := 0; i < 20; i++ {
+		tk, err := task.New(nil, 2, time.Minute, "secret", nil, "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tk.CtxCancel()
+		if tk.ID()[0] != 'A' {
+			t.Fatal(tk.ID())
+		}
+	}
+
+	task.SetIDFilter(func(id string) bool { return false })
+	if _, err := task.New(nil, 2, time.Minute, "secret", nil, "127.0.0.1"); err == nil {
+		t.Fatal("no error")
+	}
+}
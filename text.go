@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mkch/webfs/task"
+)
+
+// readText reads the content of a text task from the body of r.
+// If the content is invalid, an error is responded and ok is false.
+func readText(w http.ResponseWriter, r *http.Request) (text string, ok bool) {
+	max := getConfig().MaxTextSize
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		httpError(w, r, http.StatusRequestEntityTooLarge, "error.text_too_large", max)
+		return "", false
+	}
+	if err != nil || len(b) == 0 || !utf8.Valid(b) {
+		httpError(w, r, http.StatusBadRequest, "error.invalid_body")
+		return "", false
+	}
+	return string(b), true
+}
+
+// serveText responds the content of text task t.
+// Browsers get a page with a copy button and clickable links,
+// other clients such as curl get text/plain. The raw query parameter
+// forces text/plain.
+func serveText(w http.ResponseWriter, r *http.Request, t *task.Task, text string) {
+	t.TextDownloaded()
+	// The text is a secret of the sender.
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Has("raw") || !acceptsHTML(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := io.WriteString(w, text); err != nil {
+			t.Logger().Debug("failed to write text", "error", err)
+		}
+		return
+	}
+	renderPage(w, r, "text.html", &struct {
+		page
+		ID       string
+		Text     string
+		Segments []textSegment
+	}{newPage(r), t.ID(), text, linkify(text)})
+}
+
+// textSegment is a part of a text, either plain text or a link.
+type textSegment struct {
+	Text string
+	Link bool
+}
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// linkify splits text into plain text and links.
+func linkify(text string) (segments []textSegment) {
+	for {
+		loc := linkPattern.FindStringIndex(text)
+		if loc == nil {
+			break
+		}
+		// Punctuation at the end is likely not a part of the link.
+		link := strings.TrimRight(text[loc[0]:loc[1]], ".,;:!?)]}")
+		if loc[0] > 0 {
+			segments = append(segments, textSegment{Text: text[:loc[0]]})
+		}
+		segments = append(segments, textSegment{Text: link, Link: true})
+		text = text[loc[0]+len(link):]
+	}
+	if text != "" {
+		segments = append(segments, textSegment{Text: text})
+	}
+	return
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mkch/webfs/task"
+)
+
+func TestTextTask(t *testing.T) {
+	setConfig(t, func(c *config) { c.CodeLen = 6; c.MaxTextSize = 100 })
+	const text = "see https://example.com/a?b=c.\n<b>bold</b>"
+	w := httptest.NewRecorder()
+	handleNewTask(w, httptest.NewRequest("POST", "/new_task?type=text", strings.NewReader(text)))
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Body)
+	}
+	var newTask struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&newTask); err != nil {
+		t.Fatal(err)
+	}
+	defer task.Query(newTask.ID).CtxCancel()
+
+	// curl.
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/r/"+newTask.ID, nil)
+	r.Header.Set("Accept", "*/*")
+	handleReceiveFile(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" || w.Body.String() != text {
+		t.Fatal(w.Code, w.Header(), w.Body)
+	}
+
+	// Browser.
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/r/"+newTask.ID, nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	handleReceiveFile(w, r)
+	body, _ := io.ReadAll(w.Body)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(string(body), `<a href="https://example.com/a?b=c"`) ||
+		!strings.Contains(string(body), "&lt;b&gt;bold&lt;/b&gt;") {
+		t.Fatal(w.Code, w.Header(), string(body))
+	}
+
+	// Raw in browser.
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/r/"+newTask.ID+"?raw", nil)
+	r.Header.Set("Accept", "text/html")
+	handleReceiveFile(w, r)
+	if w.Body.String() != text {
+		t.Fatal(w.Body)
+	}
+
+	if n := task.Query(newTask.ID).TextDownloads(); n != 3 {
+		t.Fatal(n)
+	}
+}
+
+func TestTextTaskInvalid(t *testing.T) {
+	setConfig(t, func(c *config) { c.MaxTextSize = 10 })
+	for _, c := range []struct {
+		query string
+		body  string
+		code  int
+	}{
+		{"type=text", strings.Repeat("a", 11), http.StatusRequestEntityTooLarge},
+		{"type=text", "", http.StatusBadRequest},
+		{"type=text", "\xff", http.StatusBadRequest},
+		{"type=unknown", "a", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		handleNewTask(w, httptest.NewRequest("POST", "/new_task?"+c.query, strings.NewReader(c.body)))
+		if w.Code != c.code {
+			t.Fatal(c, w.Code)
+		}
+	}
+}
+
+func TestLinkify(t *testing.T) {
+	for _, c := range []struct {
+		text     string
+		segments []textSegment
+	}{
+		{"", nil},
+		{"abc", []textSegment{{"abc", false}}},
+		{"http://a.com", []textSegment{{"http://a.com", true}}},
+		{"go to https://a.com/x, (https://b.com/y) now", []textSegment{
+			{"go to ", false}, {"https://a.com/x", true}, {", (", false}, {"https://b.com/y", true}, {") now", false}}},
+	} {
+		if segments := linkify(c.text); !reflect.DeepEqual(segments, c.segments) {
+			t.Fatal(c.text, segments)
+		}
+	}
+}
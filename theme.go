@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/mkch/webfs/modfs"
+	"github.com/mkch/webfs/overlayfs"
+)
+
+// theme is the static files and templates of the pages.
+type theme struct {
+	static http.Handler
+	pages  map[string]*template.Template // Keyed by filename.
+}
+
+var currentTheme atomic.Pointer[theme]
+
+func init() {
+	t, err := loadTheme("")
+	if err != nil {
+		panic(err)
+	}
+	currentTheme.Store(t)
+}
+
+// getTheme returns the current theme.
+func getTheme() *theme {
+	return currentTheme.Load()
+}
+
+// loadTheme loads the theme of the files in dir overlaying the embedded
+// ones. The embedded theme is loaded if dir is empty.
+func loadTheme(dir string) (*theme, error) {
+	// The original ModTime of the files returned by embed.FS.Open is 0.
+	// The embedded files have the load time instead, so the responses can
+	// be cached by clients. Files in dir have their own ModTime if newer,
+	// so that no file is older than a file it replaces: overriding a file
+	// or deleting an override invalidates cached responses.
+	loaded := time.Now()
+	var staticFS, templateFS fs.FS = &modfs.FS{FS: staticFiles, LastModified: loaded}, templateFiles
+	if dir != "" {
+		staticFS = &overlayfs.FS{Upper: &modfs.FS{FS: os.DirFS(dir), LastModified: loaded, KeepNewer: true}, Lower: staticFS}
+		templateFS = &overlayfs.FS{Upper: os.DirFS(dir), Lower: templateFS}
+	}
+	// Templates in template/layout are shared by all pages, every page
+	// is parsed with a copy of them so they can define the same blocks.
+	layout, err := template.New("").Funcs(template.FuncMap{
+		"formatSize": formatSize,
+	}).ParseFS(templateFS, "template/layout/*.html")
+	if err != nil {
+		return nil, err
+	}
+	names, err := fs.Glob(templateFS, "template/*.html")
+	if err != nil {
+		return nil, err
+	}
+	t := &theme{
+		static: http.FileServer(http.FS(staticFS)),
+		pages:  make(map[string]*template.Template, len(names)),
+	}
+	for _, name := range names {
+		page, err := layout.Clone()
+		if err == nil {
+			page, err = page.ParseFS(templateFS, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.pages[path.Base(name)] = page
+	}
+	return t, nil
+}
+
+// execute renders the page of template name with data.
+func (t *theme) execute(w io.Writer, name string, data any) error {
+	page, ok := t.pages[name]
+	if !ok {
+		return fmt.Errorf("no such template: %v", name)
+	}
+	return page.ExecuteTemplate(w, name, data)
+}
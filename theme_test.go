@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeThemeFile writes a file of the theme in dir.
+func writeThemeFile(t *testing.T, dir, name, content string, modTime time.Time) {
+	t.Helper()
+	name = filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTheme(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeThemeFile(t, dir, "static/res/theme.css", "body { color: red; }", modTime)
+	writeThemeFile(t, dir, "static/res/logo.svg", "<svg></svg>", modTime)
+	writeThemeFile(t, dir, "template/layout/header.html",
+		`{{define "header"}}<img class="logo" src="{{.Root}}res/logo.svg" alt="{{.SiteTitle}}">{{end}}`, modTime)
+	setConfig(t, func(c *config) { c.ThemeDir = dir; c.SiteTitle = "ACME Share"; c.Footer = "© ACME" })
+
+	newer := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	writeThemeFile(t, dir, "static/res/new.css", "", newer)
+	loaded := time.Now().UTC().Truncate(time.Second)
+	theme, err := loadTheme(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := getTheme()
+	currentTheme.Store(theme)
+	defer currentTheme.Store(old)
+
+	// Overridden and new files are not older than the load,
+	// the newer ones have their own ModTime.
+	for _, name := range []string{"theme.css", "logo.svg"} {
+		w := httptest.NewRecorder()
+		handleRes(w, httptest.NewRequest("GET", "/res/"+name, nil))
+		if lastModified, err := http.ParseTime(w.Header().Get("Last-Modified")); w.Code != http.StatusOK || err != nil ||
+			lastModified.Before(loaded) || !lastModified.Before(newer) {
+			t.Fatal(name, w.Code, w.Header())
+		}
+	}
+	w := httptest.NewRecorder()
+	handleRes(w, httptest.NewRequest("GET", "/res/new.css", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Last-Modified") != newer.Format(http.TimeFormat) {
+		t.Fatal(w.Code, w.Header())
+	}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/res/theme.css", nil)
+	r.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+	handleRes(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+	// Builtin files are served if not overridden.
+	w = httptest.NewRecorder()
+	handleRes(w, httptest.NewRequest("GET", "/res/manifest.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Last-Modified") == modTime.Format(http.TimeFormat) {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handleSend(w, httptest.NewRequest("GET", "/send", nil))
+	body := w.Body.String()
+	for _, s := range []string{
+		`<img class="logo" src="/res/logo.svg" alt="ACME Share">`, // Overridden header.
+		`<title>Send file · ACME Share</title>`,
+		`© ACME`,
+		`href="/res/theme.css"`,
+		`id="drop_zone"`, // Builtin content.
+	} {
+		if !strings.Contains(body, s) {
+			t.Fatal(s, body)
+		}
+	}
+}
+
+func TestThemeFileDeleted(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Second * 2)
+	writeThemeFile(t, dir, "static/res/theme.css", "body { color: red; }", modTime)
+	old := getTheme()
+	defer currentTheme.Store(old)
+	theme, err := loadTheme(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentTheme.Store(theme)
+
+	// The builtin file replacing the deleted one is newer than it.
+	if err := os.Remove(filepath.Join(dir, "static", "res", "theme.css")); err != nil {
+		t.Fatal(err)
+	}
+	if theme, err = loadTheme(dir); err != nil {
+		t.Fatal(err)
+	}
+	currentTheme.Store(theme)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/res/theme.css", nil)
+	r.Header.Set("If-Modified-Since", modTime.UTC().Format(http.TimeFormat))
+	handleRes(w, r)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "color: red") {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
+
+func TestThemeInvalid(t *testing.T) {
+	dir := t.TempDir()
+	writeThemeFile(t, dir, "template/home.html", `{{template "layout" .}}{{define "content"}}{{.Bad}`, time.Now())
+	if _, err := loadTheme(dir); err == nil {
+		t.Fatal("no error")
+	}
+
+	c := defaultConfig()
+	c.ThemeDir = filepath.Join(dir, "none")
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "theme_dir") {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultTheme(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleIndex(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `<title>Web File Sharing</title>`) || !strings.Contains(body, `class="site_header"`) {
+		t.Fatal(body)
+	}
+	// Every page is rendered with the layout.
+	for name, page := range getTheme().pages {
+		if name != "admin.html" && page.Lookup("content") == nil {
+			t.Error(name, "has no content")
+		}
+	}
+}
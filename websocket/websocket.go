@@ -0,0 +1,477 @@
+// Package websocket implements the WebSocket protocol of RFC 6455.
+//
+// Only what webfs needs is implemented: the server handshake, a minimal
+// client, and whole messages of text and binary frames. Extensions and
+// subprotocols are not supported. Ping frames are answered automatically.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types, the opcodes of frames.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close codes.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseInvalidData     = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseNoStatus        = 1005
+)
+
+// DefaultReadLimit is the default max size of a message.
+const DefaultReadLimit = 1 << 20
+
+// maxControlSize is the max payload size of control frames.
+const maxControlSize = 125
+
+// acceptGUID is the GUID used to compute Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrBadHandshake is returned by Upgrade and Dial if the handshake is invalid.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// ErrReadLimit is returned by ReadMessage if a message exceeds the read limit.
+var ErrReadLimit = errors.New("websocket: message too large")
+
+// CloseError is returned by ReadMessage after a close frame is received.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed %v %v", e.Code, e.Text)
+}
+
+// protocolError is a violation of the protocol by the peer.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "websocket: " + string(e)
+}
+
+// Conn is a WebSocket connection.
+// ReadMessage must be called by one goroutine at a time, the write methods
+// are safe for concurrent use.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // Frames of clients are masked.
+
+	readLimit   int64
+	idleTimeout time.Duration
+
+	wl        sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, br: br, bw: bufio.NewWriter(conn), client: client, readLimit: DefaultReadLimit}
+}
+
+// acceptKey returns the Sec-WebSocket-Accept of key.
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains reports whether the comma separated header name of h
+// contains token, case insensitively.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade upgrades the HTTP server connection of r to the WebSocket protocol.
+// If the handshake fails, Upgrade responds an HTTP error and returns
+// ErrBadHandshake.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 ||
+		r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket handshake expected", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+	// Deadlines of the HTTP server don't apply to the hijacked connection.
+	conn.SetDeadline(time.Time{})
+	c := newConn(conn, brw.Reader, false)
+	fmt.Fprintf(c.bw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n", acceptKey(key))
+	if err := c.bw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Dial opens a client connection to rawURL, a ws or wss URL.
+func Dial(ctx context.Context, rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "ws":
+			host = net.JoinHostPort(u.Hostname(), "80")
+		case "wss":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	case "wss":
+		conn, err = (&tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}).DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := handshake(conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// handshake performs the client handshake on conn.
+func handshake(conn net.Conn, u *url.URL) (*Conn, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %v", ErrBadHandshake, resp.Status)
+	}
+	if !headerContains(resp.Header, "Upgrade", "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, ErrBadHandshake
+	}
+	return newConn(conn, br, true), nil
+}
+
+// SetReadLimit sets the max size of a message read by ReadMessage.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetIdleTimeout sets the max time waiting for a frame from the peer or
+// writing a frame. 0 means no timeout.
+// Peers can be kept alive by sending pings more often than the timeout.
+func (c *Conn) SetIdleTimeout(d time.Duration) {
+	c.idleTimeout = d
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying connection without the closing handshake.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// frameHeader is the header of a frame.
+type frameHeader struct {
+	fin    bool
+	opcode int
+	masked bool
+	mask   [4]byte
+	length int64
+}
+
+func (c *Conn) readHeader() (h frameHeader, err error) {
+	if c.idleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+	}
+	var b [8]byte
+	if _, err = io.ReadFull(c.br, b[:2]); err != nil {
+		return
+	}
+	if b[0]&0x70 != 0 {
+		return h, protocolError("reserved bits set")
+	}
+	h.fin = b[0]&0x80 != 0
+	h.opcode = int(b[0] & 0x0f)
+	h.masked = b[1]&0x80 != 0
+	h.length = int64(b[1] & 0x7f)
+	switch h.length {
+	case 126:
+		if _, err = io.ReadFull(c.br, b[:2]); err != nil {
+			return
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.br, b[:8]); err != nil {
+			return
+		}
+		if b[0]&0x80 != 0 {
+			return h, protocolError("invalid frame length")
+		}
+		h.length = int64(binary.BigEndian.Uint64(b[:8]))
+	}
+	if h.masked {
+		if _, err = io.ReadFull(c.br, h.mask[:]); err != nil {
+			return
+		}
+	}
+	if h.masked == c.client {
+		return h, protocolError("invalid masking")
+	}
+	if h.opcode >= CloseMessage {
+		if !h.fin || h.length > maxControlSize {
+			return h, protocolError("invalid control frame")
+		}
+		if h.opcode > PongMessage {
+			return h, protocolError(fmt.Sprintf("unknown opcode %v", h.opcode))
+		}
+	} else if h.opcode > BinaryMessage {
+		return h, protocolError(fmt.Sprintf("unknown opcode %v", h.opcode))
+	}
+	return
+}
+
+// readPayload reads the payload of h and appends it to buf.
+func (c *Conn) readPayload(h frameHeader, buf []byte) ([]byte, error) {
+	start := len(buf)
+	buf = append(buf, make([]byte, h.length)...)
+	if _, err := io.ReadFull(c.br, buf[start:]); err != nil {
+		return nil, err
+	}
+	if h.masked {
+		maskBytes(h.mask, buf[start:])
+	}
+	return buf, nil
+}
+
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+// ReadMessage reads the next text or binary message.
+// Control frames are handled while reading. After a close frame is
+// received, the close frame is echoed and a *CloseError is returned.
+// If the peer violates the protocol, the connection is closed with the
+// corresponding close code.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	messageType, p, err = c.readMessage()
+	var perr protocolError
+	switch {
+	case errors.As(err, &perr):
+		c.WriteClose(CloseProtocolError, "")
+	case errors.Is(err, ErrReadLimit):
+		c.WriteClose(CloseMessageTooBig, "")
+	}
+	return
+}
+
+func (c *Conn) readMessage() (messageType int, p []byte, err error) {
+	for {
+		h, err := c.readHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+		if h.opcode >= CloseMessage {
+			payload, err := c.readPayload(h, nil)
+			if err != nil {
+				return 0, nil, err
+			}
+			if err := c.handleControl(h.opcode, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		if h.opcode == 0 {
+			if messageType == 0 {
+				return 0, nil, protocolError("unexpected continuation frame")
+			}
+		} else if messageType != 0 {
+			return 0, nil, protocolError("continuation frame expected")
+		} else {
+			messageType = h.opcode
+		}
+		if int64(len(p))+h.length > c.readLimit {
+			return 0, nil, ErrReadLimit
+		}
+		if p, err = c.readPayload(h, p); err != nil {
+			return 0, nil, err
+		}
+		if h.fin {
+			if messageType == TextMessage && !utf8.Valid(p) {
+				c.WriteClose(CloseInvalidData, "")
+				return 0, nil, errors.New("websocket: invalid UTF-8 text")
+			}
+			if p == nil {
+				p = []byte{}
+			}
+			return messageType, p, nil
+		}
+	}
+}
+
+func (c *Conn) handleControl(opcode int, payload []byte) error {
+	switch opcode {
+	case PingMessage:
+		return c.WriteMessage(PongMessage, payload)
+	case CloseMessage:
+		e := &CloseError{Code: CloseNoStatus}
+		if len(payload) >= 2 {
+			e.Code = int(binary.BigEndian.Uint16(payload))
+			e.Text = string(payload[2:])
+		} else if len(payload) == 1 {
+			return protocolError("invalid close frame")
+		}
+		c.WriteClose(e.Code, "")
+		return e
+	}
+	return nil
+}
+
+// WriteMessage writes a message of messageType in a frame.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// WriteClose sends a close frame with code and text, unless one is sent.
+// No more message can be written after that.
+func (c *Conn) WriteClose(code int, text string) error {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, text...)
+		if len(payload) > maxControlSize {
+			// The text must stay valid UTF-8.
+			n := maxControlSize
+			for n > 2 && !utf8.RuneStart(payload[n]) {
+				n--
+			}
+			payload = payload[:n]
+		}
+	}
+	return c.writeFrame(CloseMessage, payload)
+}
+
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+	if c.idleTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.idleTimeout))
+	}
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | byte(opcode)
+	switch n := len(data); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		header[1] |= 0x80
+		header = append(header, mask[:]...)
+		masked := make([]byte, len(data))
+		copy(masked, data)
+		maskBytes(mask, masked)
+		data = masked
+	}
+	c.bw.Write(header)
+	c.bw.Write(data)
+	return c.bw.Flush()
+}
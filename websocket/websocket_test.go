@@ -0,0 +1,246 @@
+package websocket_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mkch/webfs/websocket"
+)
+
+// echoServer echoes messages until the connection is closed.
+// The error of the last ReadMessage is sent to errs.
+func echoServer(t *testing.T, errs chan<- error) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(100 * 1024)
+		for {
+			typ, p, err := conn.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := conn.WriteMessage(typ, p); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func wsURL(srv *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestEcho(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := echoServer(t, errs)
+	conn, err := websocket.Dial(context.Background(), wsURL(srv)+"/echo?a=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []struct {
+		typ  int
+		data []byte
+	}{
+		{websocket.TextMessage, []byte("hello")},
+		{websocket.TextMessage, []byte{}},
+		{websocket.BinaryMessage, bytes.Repeat([]byte{1, 2, 3}, 1000)}, // 16 bit length.
+		{websocket.BinaryMessage, bytes.Repeat([]byte{4, 5}, 40*1024)}, // 64 bit length.
+	} {
+		if err := conn.WriteMessage(msg.typ, msg.data); err != nil {
+			t.Fatal(err)
+		}
+		// Pings are answered while reading.
+		if err := conn.WriteMessage(websocket.PingMessage, []byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		typ, p, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != msg.typ || !bytes.Equal(p, msg.data) {
+			t.Fatal(typ, len(p))
+		}
+	}
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, make([]byte, 100*1024+1)); err != nil {
+		t.Fatal(err)
+	}
+	var closeErr *websocket.CloseError
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Fatal(err)
+	}
+	if err := <-errs; !errors.Is(err, websocket.ErrReadLimit) {
+		t.Fatal(err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := echoServer(t, errs)
+	conn, err := websocket.Dial(context.Background(), wsURL(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteClose(websocket.CloseGoingAway, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, nil); err == nil {
+		t.Fatal("written after close")
+	}
+	var closeErr *websocket.CloseError
+	if err := <-errs; !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "bye" {
+		t.Fatal(err)
+	}
+	// The close frame is echoed.
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseLongText(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := echoServer(t, errs)
+	conn, err := websocket.Dial(context.Background(), wsURL(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Cut to the control frame size at a rune boundary.
+	text := "a" + strings.Repeat("关闭", 50)
+	if err := conn.WriteClose(websocket.CloseGoingAway, text); err != nil {
+		t.Fatal(err)
+	}
+	var closeErr *websocket.CloseError
+	if err := <-errs; !errors.As(err, &closeErr) || !utf8.ValidString(closeErr.Text) ||
+		len(closeErr.Text) != 121 || !strings.HasPrefix(text, closeErr.Text) {
+		t.Fatal(err)
+	}
+}
+
+func TestBadHandshake(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := echoServer(t, errs)
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatal(resp.Status)
+	}
+	if err := <-errs; err != websocket.ErrBadHandshake {
+		t.Fatal(err)
+	}
+
+	if _, err := websocket.Dial(context.Background(), "http://localhost"); err == nil {
+		t.Fatal("dialed http")
+	}
+}
+
+// rawConn performs the handshake with srv and returns the raw connection.
+func rawConn(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The example of RFC 6455.
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal(resp.Status, resp.Header)
+	}
+	return conn, br
+}
+
+// maskedFrame returns a masked frame with a payload shorter than 126 bytes.
+func maskedFrame(b0 byte, payload string) []byte {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{b0, 0x80 | byte(len(payload))}, mask...)
+	for i := range payload {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	return frame
+}
+
+func TestFragments(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := echoServer(t, errs)
+	conn, br := rawConn(t, srv)
+	var frames []byte
+	frames = append(frames, maskedFrame(0x01, "Hel")...) // Text, not final.
+	frames = append(frames, maskedFrame(0x89, "p")...)   // Ping between fragments.
+	frames = append(frames, maskedFrame(0x80, "lo")...)  // Final continuation.
+	if _, err := conn.Write(frames); err != nil {
+		t.Fatal(err)
+	}
+	var got [9]byte
+	if _, err := io.ReadFull(br, got[:]); err != nil {
+		t.Fatal(err)
+	}
+	// Pong, then the echo unmasked.
+	if want := []byte{0x8a, 1, 'p', 0x81, 5, 'H', 'e', 'l', 'l'}; !bytes.Equal(got[:], want) {
+		t.Fatal(got)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	for name, frame := range map[string][]byte{
+		"unmasked":     {0x81, 0},
+		"continuation": maskedFrame(0x80, "a"),
+		"opcode":       maskedFrame(0x83, "a"),
+		"rsv":          maskedFrame(0xc1, "a"),
+		"control":      maskedFrame(0x09, "a"), // Fragmented ping.
+	} {
+		t.Run(name, func(t *testing.T) {
+			errs := make(chan error, 1)
+			srv := echoServer(t, errs)
+			conn, br := rawConn(t, srv)
+			if _, err := conn.Write(frame); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errs; err == nil || !strings.Contains(err.Error(), "websocket:") {
+				t.Fatal(err)
+			}
+			var got [4]byte
+			if _, err := io.ReadFull(br, got[:]); err != nil {
+				t.Fatal(err)
+			}
+			// Close 1002.
+			if want := []byte{0x88, 2, 0x03, 0xea}; !bytes.Equal(got[:], want) {
+				t.Fatal(got)
+			}
+		})
+	}
+}